@@ -0,0 +1,155 @@
+// Package api holds the gRPC API of traffikey and the conversions between its
+// messages and the traffikey types.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative traffikey.proto
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	traffikey "github.com/numkem/traffikey"
+)
+
+func fromDuration(d traffikey.Duration) *durationpb.Duration {
+	if d == 0 {
+		return nil
+	}
+
+	return durationpb.New(time.Duration(d))
+}
+
+func toDuration(d *durationpb.Duration) traffikey.Duration {
+	if d == nil {
+		return 0
+	}
+
+	return traffikey.Duration(d.AsDuration())
+}
+
+func fromHealthCheck(hc *traffikey.HealthCheck) *HealthCheck {
+	if hc == nil {
+		return nil
+	}
+
+	return &HealthCheck{
+		Interval:         fromDuration(hc.Interval),
+		Timeout:          fromDuration(hc.Timeout),
+		Jitter:           fromDuration(hc.Jitter),
+		FailureThreshold: int32(hc.FailureThreshold),
+		SuccessThreshold: int32(hc.SuccessThreshold),
+		MaxBackoff:       fromDuration(hc.MaxBackoff),
+	}
+}
+
+func toHealthCheck(hc *HealthCheck) *traffikey.HealthCheck {
+	if hc == nil {
+		return nil
+	}
+
+	return &traffikey.HealthCheck{
+		Interval:         toDuration(hc.Interval),
+		Timeout:          toDuration(hc.Timeout),
+		Jitter:           toDuration(hc.Jitter),
+		FailureThreshold: int(hc.FailureThreshold),
+		SuccessThreshold: int(hc.SuccessThreshold),
+		MaxBackoff:       toDuration(hc.MaxBackoff),
+	}
+}
+
+func FromTarget(t *traffikey.Target) *Target {
+	if t == nil {
+		return nil
+	}
+
+	target := &Target{
+		Name:         t.Name,
+		Type:         t.Type,
+		Urls:         t.ServerURLs,
+		Entrypoint:   t.Entrypoint,
+		Prefix:       t.Prefix,
+		Rule:         t.Rule,
+		Tls:          t.TLS,
+		TlsExtraKeys: t.TLSExtraKeys,
+		Monitored:    t.Monitored,
+		HealthCheck:  fromHealthCheck(t.HealthCheck),
+		Notify:       t.Notify,
+	}
+	for _, md := range t.Middlewares {
+		target.Middlewares = append(target.Middlewares, &Middleware{Name: md.Name, Kind: md.Kind, Values: md.Values})
+	}
+
+	return target
+}
+
+func ToTarget(t *Target) *traffikey.Target {
+	if t == nil {
+		return nil
+	}
+
+	target := &traffikey.Target{
+		Name:         t.Name,
+		Type:         t.Type,
+		ServerURLs:   t.Urls,
+		Entrypoint:   t.Entrypoint,
+		Prefix:       t.Prefix,
+		Rule:         t.Rule,
+		TLS:          t.Tls,
+		TLSExtraKeys: t.TlsExtraKeys,
+		Monitored:    t.Monitored,
+		HealthCheck:  toHealthCheck(t.HealthCheck),
+		Notify:       t.Notify,
+	}
+	for _, md := range t.Middlewares {
+		target.Middlewares = append(target.Middlewares, &traffikey.Middleware{Name: md.Name, Kind: md.Kind, Values: md.Values})
+	}
+
+	return target
+}
+
+func FromTargets(targets []*traffikey.Target) []*Target {
+	var values []*Target
+	for _, t := range targets {
+		values = append(values, FromTarget(t))
+	}
+
+	return values
+}
+
+func ToTargets(targets []*Target) []*traffikey.Target {
+	values := []*traffikey.Target{}
+	for _, t := range targets {
+		values = append(values, ToTarget(t))
+	}
+
+	return values
+}
+
+// FromConfig keeps the targets and the traefik defaults of the configuration
+func FromConfig(cfg *traffikey.Config) *Config {
+	if cfg == nil {
+		return nil
+	}
+
+	config := &Config{Targets: FromTargets(cfg.Targets)}
+	if cfg.Traefik != nil {
+		config.DefaultPrefix = cfg.Traefik.DefaultPrefix
+		config.DefaultEntrypoint = cfg.Traefik.DefaultEntrypoint
+	}
+
+	return config
+}
+
+func ToConfig(cfg *Config) *traffikey.Config {
+	if cfg == nil {
+		return nil
+	}
+
+	config := &traffikey.Config{Targets: ToTargets(cfg.Targets)}
+	config.SetDefaults()
+	config.Traefik.DefaultPrefix = cfg.DefaultPrefix
+	config.Traefik.DefaultEntrypoint = cfg.DefaultEntrypoint
+
+	return config
+}
@@ -0,0 +1,185 @@
+// Package client drives a traffikey server through its gRPC API. Its methods
+// mirror the ones of the keymate connectors so that tools don't need access
+// to the store.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	traffikey "github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/api"
+)
+
+// tokenCredentials sends the token with every call
+type tokenCredentials struct {
+	token    string
+	insecure bool
+}
+
+func (c *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+// RequireTransportSecurity keeps the token from being sent in clear, unless
+// the client was explicitly created with NewInsecure
+func (c *tokenCredentials) RequireTransportSecurity() bool {
+	return !c.insecure
+}
+
+type Client struct {
+	conn *grpc.ClientConn
+	rpc  api.TraffikeyClient
+}
+
+// New connects to the server at the address with the token over TLS. The
+// certificate of the server is checked against the system's authorities,
+// pass grpc.WithTransportCredentials to use another TLS configuration.
+func New(address, token string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))}, opts...)
+	return dial(address, &tokenCredentials{token: token}, opts...)
+}
+
+// NewInsecure connects to the server at the address with the token without
+// encryption. The token is sent in clear, only use it on a trusted network.
+func NewInsecure(address, token string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	return dial(address, &tokenCredentials{token: token, insecure: true}, opts...)
+}
+
+func dial(address string, creds *tokenCredentials, opts ...grpc.DialOption) (*Client, error) {
+	opts = append(opts, grpc.WithPerRPCCredentials(creds))
+
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+
+	return &Client{conn: conn, rpc: api.NewTraffikeyClient(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// singlePrefix refuses the targets with several prefixes or overrides, like
+// the HTTP API does. The gRPC API doesn't carry them, the targets would be
+// written to the default prefix of the server.
+func singlePrefix(targets ...*traffikey.Target) error {
+	for _, t := range targets {
+		if t != nil && (len(t.Prefixes) > 0 || len(t.Overrides) > 0) {
+			return fmt.Errorf("target %s: targets sent through gRPC have a single prefix, set prefix instead of prefixes and overrides", t.Name)
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) ApplyConfig(ctx context.Context, cfg *traffikey.Config) []error {
+	err := singlePrefix(cfg.Targets...)
+	if err != nil {
+		return []error{err}
+	}
+
+	resp, err := c.rpc.ApplyConfig(ctx, &api.ApplyConfigRequest{Config: api.FromConfig(cfg)})
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, e := range resp.Errors {
+		errs = append(errs, errors.New(e))
+	}
+
+	return errs
+}
+
+func (c *Client) ApplyTarget(ctx context.Context, target *traffikey.Target) error {
+	err := singlePrefix(target)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.rpc.ApplyTarget(ctx, &api.ApplyTargetRequest{Target: api.FromTarget(target)})
+	return err
+}
+
+func (c *Client) DeleteTarget(ctx context.Context, target *traffikey.Target) error {
+	err := singlePrefix(target)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.rpc.DeleteTarget(ctx, &api.DeleteTargetRequest{Target: api.FromTarget(target)})
+	return err
+}
+
+// ListTargets returns the targets found under the default prefix of the configuration
+func (c *Client) ListTargets(ctx context.Context, cfg *traffikey.Config) ([]*traffikey.Target, error) {
+	resp, err := c.rpc.ListTargets(ctx, &api.ListTargetsRequest{Prefix: cfg.Traefik.DefaultPrefix})
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ToTargets(resp.Targets), nil
+}
+
+// GetState returns the last applied configuration, nil if there is none
+func (c *Client) GetState(ctx context.Context) (*traffikey.Config, error) {
+	resp, err := c.rpc.GetState(ctx, &api.GetStateRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ToConfig(resp.State), nil
+}
+
+func (c *Client) SaveState(ctx context.Context, cfg *traffikey.Config) error {
+	err := singlePrefix(cfg.Targets...)
+	if err != nil {
+		return err
+	}
+
+	// The interpolated secrets are saved as their ${...} expressions
+	_, err = c.rpc.SaveState(ctx, &api.SaveStateRequest{State: api.FromConfig(cfg.Redacted())})
+	return err
+}
+
+// WatchState sends the new states until the context is done or the stream fails
+func (c *Client) WatchState(ctx context.Context) (<-chan *traffikey.Config, error) {
+	stream, err := c.rpc.WatchState(ctx, &api.WatchStateRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan *traffikey.Config)
+	go func() {
+		defer close(ch)
+
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					log.Warnf("state stream interrupted: %v", err)
+				}
+				return
+			}
+
+			select {
+			case ch <- api.ToConfig(resp.State):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+
+	traffikey "github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+// apiServer manages the targets of the state through HTTP
+type apiServer struct {
+	// Serializes the changes to the state
+	mu      sync.Mutex
+	manager keymate.KeymateConnector
+	cfg     *traffikey.Config
+}
+
+// apiRoute is both an echo route and an OpenAPI operation
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	// Types of the request and response bodies, nil when there is none
+	Request  reflect.Type
+	Response reflect.Type
+	Status   int
+	Handler  func(s *apiServer, c echo.Context) error
+}
+
+var (
+	targetType      = reflect.TypeOf(traffikey.Target{})
+	targetsType     = reflect.TypeOf([]*traffikey.Target{})
+	middlewareType  = reflect.TypeOf(traffikey.Middleware{})
+	middlewaresType = reflect.TypeOf([]*traffikey.Middleware{})
+)
+
+var apiRoutes = []*apiRoute{
+	{http.MethodGet, "/targets", "List the targets of the state", nil, targetsType, http.StatusOK, (*apiServer).listTargets},
+	{http.MethodPost, "/targets", "Create a target", targetType, targetType, http.StatusCreated, (*apiServer).createTarget},
+	{http.MethodGet, "/targets/:prefix/:type/:name", "Get a target", nil, targetType, http.StatusOK, (*apiServer).getTarget},
+	{http.MethodPut, "/targets/:prefix/:type/:name", "Replace a target", targetType, targetType, http.StatusOK, (*apiServer).updateTarget},
+	{http.MethodDelete, "/targets/:prefix/:type/:name", "Delete a target", nil, nil, http.StatusNoContent, (*apiServer).deleteTarget},
+	{http.MethodGet, "/targets/:prefix/:type/:name/middlewares", "List the middlewares of a target", nil, middlewaresType, http.StatusOK, (*apiServer).listMiddlewares},
+	{http.MethodPut, "/targets/:prefix/:type/:name/middlewares/:middleware", "Create or replace a middleware of a target", middlewareType, targetType, http.StatusOK, (*apiServer).putMiddleware},
+	{http.MethodDelete, "/targets/:prefix/:type/:name/middlewares/:middleware", "Delete a middleware of a target", nil, targetType, http.StatusOK, (*apiServer).deleteMiddleware},
+}
+
+// verb returns what a token must be allowed to use the route
+func (r *apiRoute) verb() string {
+	if r.Method == http.MethodGet {
+		return traffikey.VERB_READ
+	}
+
+	return traffikey.VERB_WRITE
+}
+
+// register adds the routes to the group. The targets of the API are saved in
+// a state of their own, apply would remove them from the state of the host.
+func (s *apiServer) register(g *echo.Group, auth *authenticator) {
+	for _, route := range apiRoutes {
+		handler := route.Handler
+		g.Add(route.Method, route.Path, func(c echo.Context) error {
+			c.SetRequest(c.Request().WithContext(keymate.WithStateName(c.Request().Context(), keymate.API_STATE)))
+			return handler(s, c)
+		}, auth.require(route.verb()))
+	}
+}
+
+// normalizeTarget fills the defaults of the target so that it can be compared to the ones of the state
+func normalizeTarget(cfg *traffikey.Config, tgt *traffikey.Target) {
+	if tgt.Prefix == "" {
+		tgt.Prefix = cfg.Traefik.DefaultPrefix
+	}
+	if tgt.Type == "" {
+		tgt.Type = "http"
+	}
+	if tgt.Entrypoint == "" {
+		tgt.Entrypoint = cfg.Traefik.DefaultEntrypoint
+	}
+}
+
+// state returns the saved state of the API with copies of its targets
+// normalized, or an empty one if nothing was written yet
+func (s *apiServer) state(c echo.Context) (*traffikey.Config, error) {
+	state, err := s.manager.GetState(c.Request().Context())
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if state == nil {
+		return &traffikey.Config{Etcd: s.cfg.Etcd, Traefik: s.cfg.Traefik, Targets: []*traffikey.Target{}}, nil
+	}
+
+	normalized := *state
+	normalized.Targets = make([]*traffikey.Target, 0, len(state.Targets))
+	for _, tgt := range state.Targets {
+		t := *tgt
+		normalizeTarget(s.cfg, &t)
+		normalized.Targets = append(normalized.Targets, &t)
+	}
+
+	return &normalized, nil
+}
+
+// find returns the index of the target of the path within the state, -1 if it isn't found
+func (s *apiServer) find(c echo.Context, state *traffikey.Config) int {
+	for i, tgt := range state.Targets {
+		if tgt.Prefix == c.Param("prefix") && tgt.Type == c.Param("type") && tgt.Name == c.Param("name") {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// lock serializes the changes of the state, within this server and with the
+// other processes changing the prefix
+func (s *apiServer) lock(c echo.Context, prefix string) (func(), error) {
+	s.mu.Lock()
+
+	unlock, err := s.manager.Lock(c.Request().Context(), []string{prefix})
+	if err != nil {
+		s.mu.Unlock()
+		return nil, s.storeError(err)
+	}
+
+	return func() {
+		unlock()
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *apiServer) storeError(err error) error {
+	if errors.Is(err, keymate.ErrInvalidTarget) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, keymate.ErrLocked) {
+		return echo.NewHTTPError(http.StatusLocked, err.Error())
+	}
+
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// replace writes the new version of the target at index i of the state, -1 to
+// add it. Only the targets taken from the state are interpolated, the ones of
+// the bodies can't read the environment and files of the server.
+func (s *apiServer) replace(c echo.Context, state *traffikey.Config, i int, tgt *traffikey.Target, fromState bool) error {
+	ctx := c.Request().Context()
+
+	// The targets of the state hold the ${...} expressions of their secrets
+	var interpolated *traffikey.Target
+	var err error
+	if fromState {
+		interpolated, err = state.InterpolateTarget(tgt)
+	} else {
+		interpolated, err = traffikey.UnescapeTarget(tgt)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// The keys of the previous version are only replaced once the target is
+	// valid, they are written back if writing the new ones failed
+	err = s.manager.ApplyTarget(ctx, interpolated)
+	if err != nil {
+		if i >= 0 && !errors.Is(err, keymate.ErrInvalidTarget) {
+			s.restore(ctx, state, state.Targets[i])
+		}
+
+		return s.storeError(err)
+	}
+
+	if i >= 0 {
+		state.Targets[i] = tgt
+	} else {
+		state.Targets = append(state.Targets, tgt)
+	}
+
+	err = s.manager.SaveState(ctx, state)
+	if err != nil {
+		return s.storeError(err)
+	}
+
+	log.WithField("target", tgt.Name).Infof("target %s/%s/%s written through the API", tgt.Prefix, tgt.Type, tgt.Name)
+	return nil
+}
+
+// restore writes the keys of the target of the state again
+func (s *apiServer) restore(ctx context.Context, state *traffikey.Config, tgt *traffikey.Target) {
+	previous, err := state.InterpolateTarget(tgt)
+	if err == nil {
+		err = s.manager.ApplyTarget(ctx, previous)
+	}
+	if err != nil {
+		log.WithField("target", tgt.Name).Errorf("failed to restore the previous version of the target: %v", err)
+	}
+}
+
+// Returned when a body holds ${...} expressions
+const INTERPOLATION_REJECTED = "${...} expressions are only interpolated from the configuration file, use $${ for a literal ${"
+
+// hasInterpolation returns whether a string of the value holds ${...} expressions
+func hasInterpolation(v interface{}) (bool, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+
+	return traffikey.HasInterpolation(string(b)), nil
+}
+
+// rejectInterpolation refuses the ${...} expressions in the bodies, only the
+// configuration files can read the environment and files of the server
+func rejectInterpolation(v interface{}) error {
+	found, err := hasInterpolation(v)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if found {
+		return echo.NewHTTPError(http.StatusBadRequest, INTERPOLATION_REJECTED)
+	}
+
+	return nil
+}
+
+func (s *apiServer) bindTarget(c echo.Context) (*traffikey.Target, error) {
+	tgt := new(traffikey.Target)
+	err := c.Bind(tgt)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid target: %v", err))
+	}
+
+	// Targets with several prefixes are only expanded from configuration files
+	if len(tgt.Prefixes) > 0 || len(tgt.Overrides) > 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "targets of the API have a single prefix")
+	}
+
+	err = rejectInterpolation(tgt)
+	if err != nil {
+		return nil, err
+	}
+
+	normalizeTarget(s.cfg, tgt)
+	return tgt, nil
+}
+
+func (s *apiServer) listTargets(c echo.Context) error {
+	state, err := s.state(c)
+	if err != nil {
+		return err
+	}
+
+	targets := []*traffikey.Target{}
+	for _, tgt := range state.Targets {
+		if allowed(c, traffikey.VERB_READ, tgt.Prefix, tgt.Type) {
+			targets = append(targets, tgt)
+		}
+	}
+
+	return c.JSON(http.StatusOK, targets)
+}
+
+func (s *apiServer) getTarget(c echo.Context) error {
+	state, err := s.state(c)
+	if err != nil {
+		return err
+	}
+
+	i := s.find(c, state)
+	if i < 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "target not found")
+	}
+
+	return c.JSON(http.StatusOK, state.Targets[i])
+}
+
+func (s *apiServer) createTarget(c echo.Context) error {
+	tgt, err := s.bindTarget(c)
+	if err != nil {
+		return err
+	}
+
+	// The prefix and type of new targets are only known from the body
+	if !allowed(c, traffikey.VERB_WRITE, tgt.Prefix, tgt.Type) {
+		return echo.NewHTTPError(http.StatusForbidden, "token isn't allowed to write this target")
+	}
+
+	unlock, err := s.lock(c, tgt.Prefix)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := s.state(c)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range state.Targets {
+		if t.Prefix == tgt.Prefix && t.Type == tgt.Type && t.Name == tgt.Name {
+			return echo.NewHTTPError(http.StatusConflict, "target already exists")
+		}
+	}
+
+	err = s.replace(c, state, -1, tgt, false)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, tgt)
+}
+
+func (s *apiServer) updateTarget(c echo.Context) error {
+	tgt, err := s.bindTarget(c)
+	if err != nil {
+		return err
+	}
+
+	if tgt.Prefix != c.Param("prefix") || tgt.Type != c.Param("type") || tgt.Name != c.Param("name") {
+		return echo.NewHTTPError(http.StatusBadRequest, "the prefix, type and name of the target can't be changed")
+	}
+
+	unlock, err := s.lock(c, tgt.Prefix)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := s.state(c)
+	if err != nil {
+		return err
+	}
+
+	i := s.find(c, state)
+	if i < 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "target not found")
+	}
+
+	err = s.replace(c, state, i, tgt, false)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, tgt)
+}
+
+func (s *apiServer) deleteTarget(c echo.Context) error {
+	unlock, err := s.lock(c, c.Param("prefix"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := s.state(c)
+	if err != nil {
+		return err
+	}
+
+	i := s.find(c, state)
+	if i < 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "target not found")
+	}
+
+	ctx := c.Request().Context()
+	err = s.manager.DeleteTarget(ctx, state.Targets[i])
+	if err != nil {
+		return s.storeError(err)
+	}
+
+	state.Targets = append(state.Targets[:i], state.Targets[i+1:]...)
+	err = s.manager.SaveState(ctx, state)
+	if err != nil {
+		return s.storeError(err)
+	}
+
+	log.WithField("target", c.Param("name")).Info("target deleted through the API")
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *apiServer) listMiddlewares(c echo.Context) error {
+	state, err := s.state(c)
+	if err != nil {
+		return err
+	}
+
+	i := s.find(c, state)
+	if i < 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "target not found")
+	}
+
+	middlewares := state.Targets[i].Middlewares
+	if middlewares == nil {
+		middlewares = []*traffikey.Middleware{}
+	}
+
+	return c.JSON(http.StatusOK, middlewares)
+}
+
+// updateMiddlewares applies the change of the middlewares to a copy of the target of the path
+func (s *apiServer) updateMiddlewares(c echo.Context, change func(middlewares []*traffikey.Middleware) ([]*traffikey.Middleware, error)) error {
+	unlock, err := s.lock(c, c.Param("prefix"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := s.state(c)
+	if err != nil {
+		return err
+	}
+
+	i := s.find(c, state)
+	if i < 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "target not found")
+	}
+
+	tgt := *state.Targets[i]
+	tgt.Middlewares, err = change(append([]*traffikey.Middleware{}, tgt.Middlewares...))
+	if err != nil {
+		return err
+	}
+
+	err = s.replace(c, state, i, &tgt, true)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &tgt)
+}
+
+func (s *apiServer) putMiddleware(c echo.Context) error {
+	middleware := new(traffikey.Middleware)
+	err := c.Bind(middleware)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid middleware: %v", err))
+	}
+
+	middleware.Name = c.Param("middleware")
+	if middleware.Kind == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "middleware kind cannot be empty")
+	}
+
+	err = rejectInterpolation(middleware)
+	if err != nil {
+		return err
+	}
+
+	return s.updateMiddlewares(c, func(middlewares []*traffikey.Middleware) ([]*traffikey.Middleware, error) {
+		for i, md := range middlewares {
+			if md.Name == middleware.Name {
+				middlewares[i] = middleware
+				return middlewares, nil
+			}
+		}
+
+		return append(middlewares, middleware), nil
+	})
+}
+
+func (s *apiServer) deleteMiddleware(c echo.Context) error {
+	return s.updateMiddlewares(c, func(middlewares []*traffikey.Middleware) ([]*traffikey.Middleware, error) {
+		for i, md := range middlewares {
+			if md.Name == c.Param("middleware") {
+				return append(middlewares[:i], middlewares[i+1:]...), nil
+			}
+		}
+
+		return nil, echo.NewHTTPError(http.StatusNotFound, "middleware not found")
+	})
+}
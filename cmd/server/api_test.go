@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+// stateManager keeps the states, by name, and the applied targets in memory
+type stateManager struct {
+	keymate.KeymateConnector
+	states  map[string]*traffikey.Config
+	applied map[string]*traffikey.Target
+	// Returned when taking a lock
+	lockErr error
+}
+
+func (f *stateManager) Lock(ctx context.Context, prefixes []string) (func(), error) {
+	if f.lockErr != nil {
+		return nil, f.lockErr
+	}
+
+	return func() {}, nil
+}
+
+func (f *stateManager) GetState(ctx context.Context) (*traffikey.Config, error) {
+	return f.states[keymate.StateName(ctx)], nil
+}
+
+func (f *stateManager) SaveState(ctx context.Context, cfg *traffikey.Config) error {
+	if f.states == nil {
+		f.states = make(map[string]*traffikey.Config)
+	}
+
+	f.states[keymate.StateName(ctx)] = cfg
+	return nil
+}
+
+func (f *stateManager) ApplyTarget(ctx context.Context, tgt *traffikey.Target) error {
+	if len(tgt.ServerURLs) == 0 {
+		return fmt.Errorf("%w: no server urls", keymate.ErrInvalidTarget)
+	}
+
+	f.applied[tgt.Name] = tgt
+	return nil
+}
+
+func (f *stateManager) DeleteTarget(ctx context.Context, tgt *traffikey.Target) error {
+	delete(f.applied, tgt.Name)
+	return nil
+}
+
+func apiRequest(e *echo.Echo, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPITargets(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Traefik.DefaultPrefix = "traefik"
+
+	tokens := map[string]*traffikey.TokenConfig{
+		"admin":  {Hash: traffikey.HashToken("secret"), Verbs: []string{traffikey.VERB_READ, traffikey.VERB_WRITE}},
+		"reader": {Hash: traffikey.HashToken("reader"), Verbs: []string{traffikey.VERB_READ}},
+		"other":  {Hash: traffikey.HashToken("other"), Prefixes: []string{"other"}, Verbs: []string{traffikey.VERB_READ, traffikey.VERB_WRITE}},
+	}
+	auth := newAuthenticator(func() map[string]*traffikey.TokenConfig { return tokens })
+
+	mgr := &stateManager{applied: make(map[string]*traffikey.Target)}
+	e := newAPIServer(&apiServer{manager: mgr, cfg: cfg}, auth)
+
+	rec := apiRequest(e, http.MethodGet, "/api/v1/targets", "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "missing token")
+	rec = apiRequest(e, http.MethodGet, "/api/v1/targets", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = apiRequest(e, http.MethodPost, "/api/v1/targets", "reader", `{"name": "web", "urls": ["http://10.0.0.1"]}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "read only token")
+	rec = apiRequest(e, http.MethodPost, "/api/v1/targets", "other", `{"name": "web", "urls": ["http://10.0.0.1"]}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "token of another prefix")
+
+	rec = apiRequest(e, http.MethodPost, "/api/v1/targets", "secret", `{"name": "web", "urls": []}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "invalid targets are rejected")
+
+	rec = apiRequest(e, http.MethodPost, "/api/v1/targets", "secret", `{"name": "web", "urls": ["http://10.0.0.1"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Contains(t, mgr.applied, "web")
+	assert.Equal(t, "traefik", mgr.applied["web"].Prefix)
+	assert.Len(t, mgr.states[keymate.API_STATE].Targets, 1)
+
+	rec = apiRequest(e, http.MethodPost, "/api/v1/targets", "secret", `{"name": "web", "urls": ["http://10.0.0.1"]}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = apiRequest(e, http.MethodPut, "/api/v1/targets/traefik/http/web", "secret", `{"name": "web", "urls": []}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Contains(t, mgr.applied, "web", "the keys of the target are kept when its new version is invalid")
+	assert.Equal(t, []string{"http://10.0.0.1"}, mgr.applied["web"].ServerURLs)
+
+	rec = apiRequest(e, http.MethodPut, "/api/v1/targets/traefik/http/web/middlewares/auth", "secret", `{"kind": "basicAuth", "values": {"users": "a:${file:/etc/shadow}"}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "only configuration files are interpolated")
+
+	rec = apiRequest(e, http.MethodPut, "/api/v1/targets/traefik/http/web/middlewares/auth", "secret", `{"kind": "basicAuth", "values": {"users": "a:b"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, mgr.applied["web"].Middlewares, 1)
+	assert.Equal(t, "auth", mgr.applied["web"].Middlewares[0].Name)
+
+	rec = apiRequest(e, http.MethodPut, "/api/v1/targets/traefik/http/web/middlewares/rewrite", "secret", `{"kind": "replacePathRegex", "values": {"replacement": "/$${HOME}/${1}"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, mgr.applied["web"].Middlewares, 2)
+	assert.Equal(t, "/${HOME}/${1}", mgr.applied["web"].Middlewares[1].Values["replacement"], "escaped expressions are written as they are")
+
+	rec = apiRequest(e, http.MethodGet, "/api/v1/targets", "other", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, "[]", rec.Body.String(), "targets of other prefixes are hidden")
+	rec = apiRequest(e, http.MethodDelete, "/api/v1/targets/traefik/http/web", "other", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = apiRequest(e, http.MethodGet, "/api/v1/targets/traefik/http/web", "reader", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	tgt := new(traffikey.Target)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), tgt))
+	assert.Equal(t, []string{"http://10.0.0.1"}, tgt.ServerURLs)
+
+	rec = apiRequest(e, http.MethodDelete, "/api/v1/targets/traefik/http/web", "secret", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, mgr.applied)
+	assert.Empty(t, mgr.states[keymate.API_STATE].Targets)
+
+	rec = apiRequest(e, http.MethodGet, "/api/v1/targets/traefik/http/web", "secret", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	mgr.lockErr = fmt.Errorf("%w: held by someone", keymate.ErrLocked)
+	rec = apiRequest(e, http.MethodPost, "/api/v1/targets", "secret", `{"name": "web", "urls": ["http://10.0.0.1"]}`)
+	assert.Equal(t, http.StatusLocked, rec.Code, "prefix locked by another process")
+	assert.Empty(t, mgr.applied)
+}
+
+func TestAPIState(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Traefik.DefaultPrefix = "traefik"
+
+	tokens := map[string]*traffikey.TokenConfig{
+		"admin": {Hash: traffikey.HashToken("secret"), Verbs: []string{traffikey.VERB_READ, traffikey.VERB_WRITE}},
+	}
+	auth := newAuthenticator(func() map[string]*traffikey.TokenConfig { return tokens })
+
+	applied := &traffikey.Config{Traefik: cfg.Traefik, Targets: []*traffikey.Target{{Name: "app", ServerURLs: []string{"http://10.0.0.2"}}}}
+	api := &traffikey.Config{Traefik: cfg.Traefik, Targets: []*traffikey.Target{{Name: "old", ServerURLs: []string{"http://10.0.0.3"}}}}
+	mgr := &stateManager{
+		states:  map[string]*traffikey.Config{"": applied, keymate.API_STATE: api},
+		applied: make(map[string]*traffikey.Target),
+	}
+	e := newAPIServer(&apiServer{manager: mgr, cfg: cfg}, auth)
+
+	rec := apiRequest(e, http.MethodGet, "/api/v1/targets", "secret", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"prefix":"traefik"`, "targets are listed with their defaults")
+	assert.Empty(t, api.Targets[0].Prefix, "the targets of the saved state are left as they are")
+
+	rec = apiRequest(e, http.MethodPost, "/api/v1/targets", "secret", `{"name": "web", "urls": ["http://10.0.0.1"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Len(t, mgr.states[keymate.API_STATE].Targets, 2)
+
+	// apply compares its configuration to the state of the host, the targets
+	// of the API aren't in it so they aren't removed
+	state, err := mgr.GetState(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, applied, state)
+	require.Len(t, state.Targets, 1)
+	assert.Equal(t, "app", state.Targets[0].Name)
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	e := newAPIServer(&apiServer{}, newAuthenticator(func() map[string]*traffikey.TokenConfig { return nil }))
+
+	rec := apiRequest(e, http.MethodGet, "/openapi.json", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var document struct {
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+
+	assert.Contains(t, document.Paths["/targets"], "post")
+	assert.Contains(t, document.Paths["/targets/{prefix}/{type}/{name}"], "delete")
+	assert.Contains(t, document.Components.Schemas, "Target")
+	assert.Contains(t, document.Components.Schemas, "Middleware")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	log "github.com/sirupsen/logrus"
+
+	traffikey "github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+const authorizationContextKey = "authorization"
+
+// tokenAuthor is the author of the revisions saved with the token
+func tokenAuthor(name string) string {
+	return "token " + name
+}
+
+// authorization is the token used by a request
+type authorization struct {
+	Name  string
+	Token *traffikey.TokenConfig
+}
+
+// authenticator checks the tokens of the requests against the ones of the configuration
+type authenticator struct {
+	tokens func() map[string]*traffikey.TokenConfig
+}
+
+func newAuthenticator(tokens func() map[string]*traffikey.TokenConfig) *authenticator {
+	return &authenticator{tokens: tokens}
+}
+
+func (a *authenticator) lookup(key string) *authorization {
+	for name, token := range a.tokens() {
+		if token.Matches(key) {
+			return &authorization{Name: name, Token: token}
+		}
+	}
+
+	return nil
+}
+
+func auditFields(c echo.Context, name, verb string) log.Fields {
+	return log.Fields{
+		"token":  name,
+		"verb":   verb,
+		"method": c.Request().Method,
+		"path":   c.Request().URL.Path,
+		"remote": c.RealIP(),
+	}
+}
+
+// closed returns whether no tokens are configured, in which case only the
+// status of the monitor can be read
+func (a *authenticator) closed() bool {
+	return len(a.tokens()) == 0
+}
+
+// require returns a middleware only letting through the requests whose token
+// allows the verb on the prefix and type of the path, if any. When no tokens
+// are configured, every request is refused.
+func (a *authenticator) require(verb string) echo.MiddlewareFunc {
+	return a.middleware(verb, false)
+}
+
+// status returns the middleware of the endpoints telling the health of the
+// monitor, which can be read without a token when no tokens are configured
+func (a *authenticator) status() echo.MiddlewareFunc {
+	return a.middleware(traffikey.VERB_READ, true)
+}
+
+func (a *authenticator) middleware(verb string, open bool) echo.MiddlewareFunc {
+	keyAuth := middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		KeyLookup: "header:" + echo.HeaderAuthorization,
+		Skipper: func(c echo.Context) bool {
+			return a.closed()
+		},
+		Validator: func(key string, c echo.Context) (bool, error) {
+			auth := a.lookup(key)
+			if auth == nil {
+				log.WithFields(auditFields(c, "", verb)).Warn("request with an unknown token denied")
+				return false, nil
+			}
+
+			c.Set(authorizationContextKey, auth)
+			return true, nil
+		},
+	})
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return keyAuth(func(c echo.Context) error {
+			auth, _ := c.Get(authorizationContextKey).(*authorization)
+			if auth == nil {
+				if !open {
+					log.WithFields(auditFields(c, "", verb)).Warn("request denied, no tokens are configured")
+					return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("no tokens are configured, %s isn't allowed", verb))
+				}
+
+				return next(c)
+			}
+
+			if !auth.Token.Allows(verb, c.Param("prefix"), c.Param("type")) {
+				log.WithFields(auditFields(c, auth.Name, verb)).Warn("request denied")
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("token %s isn't allowed to %s", auth.Name, verb))
+			}
+
+			c.SetRequest(c.Request().WithContext(keymate.WithAuthor(c.Request().Context(), tokenAuthor(auth.Name))))
+			err := next(c)
+
+			status := c.Response().Status
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			}
+
+			entry := log.WithFields(auditFields(c, auth.Name, verb)).WithField("status", status)
+			if verb == traffikey.VERB_READ {
+				entry.Debug("request")
+			} else {
+				entry.Info("request")
+			}
+
+			return err
+		})
+	}
+}
+
+// allowed returns whether the token of the request allows the verb on the
+// prefix and type. Requests without tokens were let through by status to read.
+func allowed(c echo.Context, verb, prefix, routerType string) bool {
+	auth, _ := c.Get(authorizationContextKey).(*authorization)
+	if auth == nil {
+		return true
+	}
+
+	return auth.Token.Allows(verb, prefix, routerType)
+}
+
+// allowedKey is allowed for the prefix and type of a <prefix>/<type>/<name> target key
+func allowedKey(c echo.Context, verb, key string) bool {
+	prefix, routerType, ok := splitTargetKey(key)
+	if !ok {
+		// Only tokens without scopes are allowed
+		return allowed(c, verb, key, key)
+	}
+
+	return allowed(c, verb, prefix, routerType)
+}
+
+// splitTargetKey returns the prefix and router type of a target key. Prefixes
+// can have several segments, the last two are the router type and the name.
+func splitTargetKey(key string) (string, string, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) < 3 {
+		return "", "", false
+	}
+
+	return strings.Join(parts[:len(parts)-2], "/"), parts[len(parts)-2], true
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	traffikey "github.com/numkem/traffikey"
+)
+
+const (
+	// Delay between two checks of the certificates of a target
+	CERTIFICATE_CHECK_INTERVAL = time.Hour
+	CERTIFICATE_CHECK_TIMEOUT  = 5 * time.Second
+
+	DEFAULT_CERTIFICATE_WARNING_DAYS = 14
+)
+
+var (
+	hostRuleRegexp   = regexp.MustCompile("Host\\(([^)]*)\\)")
+	ruleValuesRegexp = regexp.MustCompile("`([^`]+)`")
+)
+
+// CertificateState is the certificate presented by an endpoint of a target
+type CertificateState struct {
+	// Either public for the host of the router's rule or backend for a server
+	Endpoint   string    `json:"endpoint"`
+	Address    string    `json:"address"`
+	ServerName string    `json:"server_name"`
+	NotAfter   time.Time `json:"not_after"`
+	Issuer     string    `json:"issuer"`
+	DNSNames   []string  `json:"dns_names"`
+	// The certificate covers the server name
+	HostnameValid bool      `json:"hostname_valid"`
+	Error         string    `json:"error,omitempty"`
+	CheckedAt     time.Time `json:"checked_at"`
+}
+
+// DaysLeft returns the number of days before the certificate expires
+func (c *CertificateState) DaysLeft(now time.Time) int {
+	return int(c.NotAfter.Sub(now).Hours() / 24)
+}
+
+type certificateEndpoint struct {
+	Endpoint   string
+	Address    string
+	ServerName string
+}
+
+// ruleHosts returns the hostnames matched by the Host matchers of a rule
+func ruleHosts(rule string) []string {
+	var hosts []string
+	for _, matcher := range hostRuleRegexp.FindAllStringSubmatch(rule, -1) {
+		for _, value := range ruleValuesRegexp.FindAllStringSubmatch(matcher[1], -1) {
+			if value[1] != "*" {
+				hosts = append(hosts, value[1])
+			}
+		}
+	}
+
+	return hosts
+}
+
+// certificateEndpoints returns the endpoints serving a certificate for the target:
+// the public hosts of TLS http routers and the https servers. The port of the
+// entrypoints of tcp routers isn't known, they are left out.
+func certificateEndpoints(tgt *traffikey.Target) []*certificateEndpoint {
+	var endpoints []*certificateEndpoint
+
+	if tgt.TLS && (tgt.Type == "http" || tgt.Type == "") {
+		for _, host := range ruleHosts(tgt.Rule) {
+			endpoints = append(endpoints, &certificateEndpoint{
+				Endpoint:   "public",
+				Address:    net.JoinHostPort(host, "443"),
+				ServerName: host,
+			})
+		}
+	}
+
+	for _, serverURL := range tgt.ServerURLs {
+		u, err := url.Parse(serverURL)
+		if err != nil || u.Scheme != "https" {
+			continue
+		}
+
+		address := u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(u.Hostname(), "443")
+		}
+
+		endpoints = append(endpoints, &certificateEndpoint{
+			Endpoint:   "backend",
+			Address:    address,
+			ServerName: u.Hostname(),
+		})
+	}
+
+	return endpoints
+}
+
+// checkCertificate does a TLS handshake with the endpoint and records the
+// certificate it presents, even if it isn't valid
+func checkCertificate(ctx context.Context, ep *certificateEndpoint) *CertificateState {
+	state := &CertificateState{
+		Endpoint:   ep.Endpoint,
+		Address:    ep.Address,
+		ServerName: ep.ServerName,
+		CheckedAt:  time.Now(),
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: CERTIFICATE_CHECK_TIMEOUT},
+		Config: &tls.Config{
+			ServerName: ep.ServerName,
+			// Validation is done below, we want to know about invalid certificates too
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", ep.Address)
+	if err != nil {
+		state.Error = fmt.Sprintf("handshake failed: %v", err)
+		return state
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		state.Error = "no certificate presented"
+		return state
+	}
+
+	cert := certs[0]
+	state.NotAfter = cert.NotAfter
+	state.Issuer = cert.Issuer.String()
+	state.DNSNames = cert.DNSNames
+	state.HostnameValid = cert.VerifyHostname(ep.ServerName) == nil
+
+	return state
+}
+
+// certificateProblem describes what is wrong with the certificate, if anything
+func certificateProblem(cert *CertificateState, warningDays int, now time.Time) string {
+	switch {
+	case cert.Error != "":
+		return cert.Error
+	case !cert.NotAfter.After(now):
+		return fmt.Sprintf("expired on %s", cert.NotAfter.Format(time.DateOnly))
+	case cert.DaysLeft(now) < warningDays:
+		return fmt.Sprintf("expires in %d days on %s", cert.DaysLeft(now), cert.NotAfter.Format(time.DateOnly))
+	case !cert.HostnameValid:
+		return fmt.Sprintf("isn't valid for %s (covers %s)", cert.ServerName, strings.Join(cert.DNSNames, ", "))
+	}
+
+	return ""
+}
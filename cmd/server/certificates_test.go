@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/numkem/traffikey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleHosts(t *testing.T) {
+	hosts := ruleHosts("Host(`a.example.com`, `b.example.com`) && PathPrefix(`/api`) || Host(`*`)")
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, hosts)
+}
+
+func TestCheckCertificate(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	endpoints := certificateEndpoints(&traffikey.Target{
+		Name:       "backend",
+		Type:       "http",
+		ServerURLs: []string{srv.URL, "http://127.0.0.1:8080"},
+	})
+	require.Len(t, endpoints, 1, "only https servers should be checked")
+
+	cert := checkCertificate(context.Background(), endpoints[0])
+	assert.Empty(t, cert.Error)
+	assert.True(t, cert.HostnameValid, "test certificate covers 127.0.0.1")
+	assert.Contains(t, cert.DNSNames, "example.com")
+
+	// The test certificate is valid until 2084
+	assert.Empty(t, certificateProblem(cert, 14, time.Now()))
+
+	problem := certificateProblem(cert, 14, cert.NotAfter.Add(-72*time.Hour))
+	assert.True(t, strings.HasPrefix(problem, "expires in 3 days"), problem)
+}
+
+func TestStartCertificatesCheck(t *testing.T) {
+	status := newTargetStatus("traefik/http/web", "web")
+
+	require.True(t, status.startCertificatesCheck())
+	assert.False(t, status.startCertificatesCheck(), "a check is already running")
+
+	status.cancelCertificatesCheck()
+	require.True(t, status.startCertificatesCheck(), "a cancelled check should be started again")
+
+	status.updateCertificates(nil)
+	assert.False(t, status.startCertificatesCheck(), "the certificates were just checked")
+}
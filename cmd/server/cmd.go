@@ -2,25 +2,64 @@ package main
 
 import (
 	"os"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	traffikey "github.com/numkem/traffikey"
 )
 
 var (
 	rootCmd = &cobra.Command{
-		Use:   "traffikey",
-		Short: "A tool to write key/values for traefik configuration",
+		Use:               "traffikey",
+		Short:             "A tool to write key/values for traefik configuration",
+		PersistentPreRunE: etcdFlagsToEnv,
 	}
 )
 
+// Flags overriding the etcd configuration, through the TRAFFIKEY_ETCD_* environment variables
+var etcdFlags = map[string]string{
+	"etcd-endpoints":          "comma separated etcd endpoints",
+	"etcd-username":           "etcd username",
+	"etcd-password":           "etcd password, prefer $" + traffikey.ETCD_ENV_PREFIX + "PASSWORD to keep it out of the process list",
+	"etcd-ca-cert":            "file of the certificate authorities of etcd",
+	"etcd-cert":               "file of the client certificate for etcd",
+	"etcd-key":                "file of the key of the etcd client certificate",
+	"etcd-server-name":        "name checked in the certificate of etcd",
+	"etcd-dial-timeout":       "how long to wait for the connection to etcd",
+	"etcd-keepalive-time":     "ping etcd after this long without activity",
+	"etcd-keepalive-timeout":  "how long to wait for the answer of etcd to a ping",
+	"etcd-auto-sync-interval": "refresh the etcd endpoints from the members of the cluster at this interval",
+}
+
 func init() {
 	if os.Getenv("DEBUG") != "" {
 		log.Info("debug level set")
 		log.SetLevel(log.DebugLevel)
 	}
 
-	rootCmd.PersistentFlags().StringP("config", "c", "traffikey.json", "json configuration filename")
+	rootCmd.PersistentFlags().StringP("config", "c", "traffikey.json", "json or yaml configuration file, directory or glob")
+	for name, usage := range etcdFlags {
+		rootCmd.PersistentFlags().String(name, "", usage)
+	}
+}
+
+// etcdFlagsToEnv passes the etcd flags that were given to the configuration,
+// which reads them from the environment every time it is loaded
+func etcdFlagsToEnv(cmd *cobra.Command, args []string) error {
+	var err error
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if _, ok := etcdFlags[f.Name]; !ok || err != nil {
+			return
+		}
+
+		name := strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(f.Name, "etcd-"), "-", "_"))
+		err = os.Setenv(traffikey.ETCD_ENV_PREFIX+name, f.Value.String())
+	})
+
+	return err
 }
 
 func Execute() error {
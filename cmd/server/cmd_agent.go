@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "registers the targets of the configuration for as long as it runs",
+	Long:  "registers the targets of the configuration with keys attached to a lease kept alive by the agent. When the agent stops or its host dies, the keys expire and traefik drops the routes. The state isn't changed.",
+	Run:   agentCmdRun,
+}
+
+// Seconds before the keys of an agent that stopped renewing its lease expire
+const DEFAULT_AGENT_TTL = 10
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.PersistentFlags().Int("ttl", DEFAULT_AGENT_TTL, "TTL in seconds of the lease of the registered targets")
+}
+
+func agentCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	if len(cfg.Targets) == 0 {
+		log.Fatalf("no targets to register in %s", configFilename)
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	ttl, _ := cmd.Flags().GetInt("ttl")
+	registrations, err := mgr.RegisterTargets(ctx, cfg.Targets, ttl)
+	if err != nil {
+		log.Fatalf("failed to register targets: %v", err)
+	}
+
+	for registered := range registrations {
+		if registered {
+			log.Infof("%d targets registered", len(cfg.Targets))
+		} else {
+			log.Warn("lease lost, registering the targets again")
+		}
+	}
+
+	log.Info("targets unregistered")
+}
@@ -1,24 +1,34 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os/signal"
+	"slices"
 	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 
 	traffikey "github.com/numkem/traffikey"
 	"github.com/numkem/traffikey/keymate"
-
-	"github.com/spf13/cobra"
 )
 
 var applyConfigCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "apply the configuration file and write the key/values to the store",
+	Long:  "apply the configuration file by writing only the keys that differ from the store. With --watch, the configuration is applied again when the file changes and the keys changed by hand under the prefixes of the configuration are corrected.",
 	Run:   applyConfigCmdRun,
 }
 
 func init() {
 	rootCmd.AddCommand(applyConfigCmd)
 	rootCmd.MarkFlagRequired("config")
+	applyConfigCmd.PersistentFlags().Bool("dry-run", false, "show the changes without writing them")
+	applyConfigCmd.PersistentFlags().Bool("watch", false, "keep the store in sync with the configuration file")
+	applyConfigCmd.PersistentFlags().Duration("lock-timeout", 0, "how long to wait for the locks held by other processes (defaults to the configuration or 30s)")
+	applyConfigCmd.PersistentFlags().Bool("force-unlock", false, "release the locks of the state and prefixes whoever holds them before applying")
 }
 
 func applyConfigCmdRun(cmd *cobra.Command, args []string) {
@@ -39,6 +49,10 @@ func applyConfigCmdRun(cmd *cobra.Command, args []string) {
 		cmd.Printf("WARN: default Traefik entrypoint isn't set, this could cause errors\n")
 	}
 
+	if timeout, _ := cmd.Flags().GetDuration("lock-timeout"); timeout != 0 {
+		cfg.Etcd.LockTimeout = traffikey.Duration(timeout)
+	}
+
 	// Create manager connection
 	mgr, err := keymate.NewEtcdManager(cfg)
 	if err != nil {
@@ -49,43 +63,50 @@ func applyConfigCmdRun(cmd *cobra.Command, args []string) {
 	ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
 
-	errs := mgr.ApplyConfig(ctx, cfg)
-	for _, err := range errs {
-		cmd.PrintErrf("ERR: error found while applying configuration: %v\n", err)
+	if force, _ := cmd.Flags().GetBool("force-unlock"); force {
+		err = forceUnlock(ctx, mgr, cfg)
+		if err != nil {
+			cmd.PrintErrf("ERR: %v\n", err)
+			return
+		}
+	}
+
+	if watch, _ := cmd.Flags().GetBool("watch"); watch {
+		watchApply(ctx, mgr, configFilename, cfg)
 		return
 	}
 
-	// Get previous state and compare to see if some tagerts were removed
+	unlock, err := lockState(ctx, mgr, cfg)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+	defer unlock()
+
+	// The previous state tells which targets were removed from the configuration
 	oldState, err := mgr.GetState(ctx)
 	if err != nil {
 		cmd.PrintErrf("ERR: failed to get previous state: %v\n", err)
 		return
 	}
 
-	if oldState != nil {
-		// Go through the old state and check for each target to see if they still exists
-		for _, ot := range oldState.Targets {
-			var found bool
-			for _, t := range cfg.Targets {
-				if ot.Name == t.Name {
-					found = true
-				}
-			}
+	plan, err := mgr.Plan(ctx, cfg, oldState)
+	if err != nil {
+		cmd.PrintErrf("ERR: error found while applying configuration: %v\n", err)
+		return
+	}
 
-			if !found {
-				prefix := oldState.Traefik.DefaultPrefix
-				if ot.Prefix != "" {
-					prefix = ot.Prefix
-				}
+	printPlan(cmd, plan.Redacted(cfg.Redact))
 
-				cmd.Printf("INF: deleting removed target %s\n", ot.Name)
-				err = mgr.DeleteTargetByName(ctx, ot.Name, prefix)
-				if err != nil {
-					cmd.PrintErrf("ERR: failed to delete old target named %s: %v", ot.Name, err)
-					return
-				}
-			}
-		}
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		cmd.Print("dry run, nothing was changed\n")
+		return
+	}
+
+	err = mgr.ApplyPlan(ctx, plan)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
 	}
 
 	err = mgr.SaveState(ctx, cfg)
@@ -96,3 +117,207 @@ func applyConfigCmdRun(cmd *cobra.Command, args []string) {
 
 	cmd.Print("configuration applied!\n")
 }
+
+func printPlan(cmd *cobra.Command, plan *keymate.Plan) {
+	for _, target := range plan.Removed {
+		cmd.Printf("INF: deleting removed target %s\n", keymate.TargetKey(target))
+	}
+
+	for _, c := range plan.Puts {
+		if c.Current == "" {
+			cmd.Printf("+ %s = %s\n", c.Key, c.Wanted)
+		} else {
+			cmd.Printf("~ %s = %s (was %s)\n", c.Key, c.Wanted, c.Current)
+		}
+	}
+
+	for _, c := range plan.Deletes {
+		cmd.Printf("- %s\n", c.Key)
+	}
+
+	if plan.Empty() {
+		cmd.Print("INF: store is up to date\n")
+	}
+}
+
+// statePrefixes returns the prefixes of the configuration and of the saved state
+func statePrefixes(ctx context.Context, mgr keymate.KeymateConnector, cfg *traffikey.Config) ([]string, error) {
+	state, err := mgr.GetState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get previous state: %v", err)
+	}
+
+	prefixes := cfg.Prefixes()
+	if state != nil {
+		state.SetDefaults()
+		prefixes = append(prefixes, state.Prefixes()...)
+	}
+
+	return prefixes, nil
+}
+
+// lockState takes the locks of the state and of the prefixes that applying the configuration changes
+func lockState(ctx context.Context, mgr keymate.KeymateConnector, cfg *traffikey.Config) (func(), error) {
+	prefixes, err := statePrefixes(ctx, mgr, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return mgr.Lock(ctx, prefixes)
+}
+
+// forceUnlock releases the locks that applying the configuration takes
+func forceUnlock(ctx context.Context, mgr keymate.KeymateConnector, cfg *traffikey.Config) error {
+	prefixes, err := statePrefixes(ctx, mgr, cfg)
+	if err != nil {
+		return err
+	}
+
+	names, err := keymate.LockNames(prefixes)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		holder, err := mgr.LockHolder(ctx, name)
+		if err != nil {
+			return err
+		}
+		if holder == "" {
+			continue
+		}
+
+		log.Warnf("releasing lock %s held by %s", name, holder)
+		err = mgr.ForceUnlock(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcile makes the store match the configuration and logs what was corrected
+func reconcile(ctx context.Context, mgr keymate.KeymateConnector, cfg *traffikey.Config, saveState bool) error {
+	unlock, err := lockState(ctx, mgr, cfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := mgr.GetState(ctx)
+	if err != nil {
+		return err
+	}
+
+	plan, err := mgr.Plan(ctx, cfg, state)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range plan.Removed {
+		log.Infof("deleting removed target %s", keymate.TargetKey(target))
+	}
+	for _, c := range plan.Redacted(cfg.Redact).Puts {
+		entry := log.WithFields(log.Fields{"target": c.Target, "key": c.Key})
+		if c.Current == "" {
+			entry.Infof("restoring missing key")
+		} else {
+			entry.WithField("was", c.Current).Infof("correcting modified key")
+		}
+	}
+	for _, c := range plan.Deletes {
+		log.WithFields(log.Fields{"target": c.Target, "key": c.Key}).Info("deleting stale key")
+	}
+
+	err = mgr.ApplyPlan(ctx, plan)
+	if err != nil {
+		return err
+	}
+
+	if saveState {
+		return mgr.SaveState(ctx, cfg)
+	}
+
+	return nil
+}
+
+// watchApply applies the configuration every time its file changes and
+// corrects the keys changed in the store until the context is done
+func watchApply(ctx context.Context, mgr keymate.KeymateConnector, configFilename string, cfg *traffikey.Config) {
+	err := reconcile(ctx, mgr, cfg, true)
+	if err != nil {
+		log.Fatalf("failed to apply configuration: %v", err)
+	}
+	log.Info("configuration applied, watching for changes")
+
+	files, err := watchFile(ctx, configPaths(configFilename, cfg)...)
+	if err != nil {
+		log.Fatalf("failed to watch configuration: %v", err)
+	}
+
+	prefixes := cfg.Prefixes()
+	keysCtx, stopKeys := context.WithCancel(ctx)
+	keys, err := mgr.WatchPrefixes(keysCtx, prefixes)
+	if err != nil {
+		log.Fatalf("failed to watch the store: %v", err)
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			stopKeys()
+			return
+
+		case _, ok := <-files:
+			if !ok {
+				stopKeys()
+				return
+			}
+
+			newCfg, err := traffikey.NewConfig(configFilename)
+			if err != nil {
+				log.Errorf("failed to reload configuration, keeping the previous one: %v", err)
+				continue
+			}
+
+			err = reconcile(ctx, mgr, newCfg, true)
+			if err != nil {
+				log.Errorf("failed to apply configuration: %v", err)
+				continue
+			}
+			cfg = newCfg
+			log.Info("configuration reloaded and applied")
+
+			// Targets can move to other prefixes
+			if p := cfg.Prefixes(); !slices.Equal(p, prefixes) {
+				stopKeys()
+
+				prefixes = p
+				keysCtx, stopKeys = context.WithCancel(ctx)
+				keys, err = mgr.WatchPrefixes(keysCtx, prefixes)
+				if err != nil {
+					log.Fatalf("failed to watch the store: %v", err)
+				}
+			}
+
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			log.Debugf("key %s changed", key)
+			debounce = time.After(CONFIG_RELOAD_DEBOUNCE)
+
+		case <-debounce:
+			debounce = nil
+
+			err := reconcile(ctx, mgr, cfg, false)
+			if err != nil {
+				log.Errorf("failed to correct the store: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "writes the keys of prefixes and the state of traffikey to a file",
+	Long:  "writes the keys of the prefixes and the ones of traffikey under " + keymate.ETCD_CONFIG_PREFIX + " as JSON lines, compressed when the file ends with .gz. Keys attached to a lease, like the ones of agents, are left out.",
+	Run:   backupCmdRun,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "writes the keys of a backup back to the store",
+	Long:  "writes the keys of a backup back to the store. By default the keys that aren't in the backup are kept, with --replace the prefixes of the backup end up holding exactly its keys.",
+	Args:  cobra.ExactArgs(1),
+	Run:   restoreCmdRun,
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+	backupCmd.PersistentFlags().StringSliceP("prefix", "p", nil, "etcd key prefixes to backup (defaults to the ones of the configuration)")
+	backupCmd.PersistentFlags().StringP("output", "o", "", "file to write (defaults to stdout)")
+	restoreCmd.PersistentFlags().Bool("replace", false, "delete the keys of the prefixes that aren't in the backup")
+	restoreCmd.PersistentFlags().Bool("dry-run", false, "show the changes without writing them")
+}
+
+func backupCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+	output := cmd.Flag("output").Value.String()
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	prefixes, _ := cmd.Flags().GetStringSlice("prefix")
+	if len(prefixes) == 0 {
+		prefixes = cfg.Prefixes()
+	}
+
+	var header *keymate.BackupHeader
+	backup := func(w io.Writer) error {
+		header, err = mgr.Backup(cmd.Context(), prefixes, w)
+		return err
+	}
+
+	if output == "" {
+		err = backup(os.Stdout)
+	} else {
+		err = writeBackupFile(output, backup)
+	}
+	if err != nil {
+		log.Fatalf("failed to backup: %v", err)
+	}
+
+	log.Infof("prefixes %s saved at etcd revision %d", strings.Join(header.Prefixes, ", "), header.EtcdRevision)
+}
+
+// writeBackupFile writes the backup to the file, compressed when its name ends
+// with .gz. The file is removed when the backup or closing it fails so that
+// no partial backup is left behind.
+func writeBackupFile(filename string, backup func(w io.Writer) error) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", filename, err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(filename)
+		}
+	}()
+
+	var gz *gzip.Writer
+	var w io.Writer = f
+	if strings.HasSuffix(filename, ".gz") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+
+	err = backup(w)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to write %s: %v", filename, cerr)
+	}
+
+	return err
+}
+
+func restoreCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Fatalf("failed to open backup: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(args[0], ".gz") {
+		r, err = gzip.NewReader(f)
+		if err != nil {
+			log.Fatalf("failed to decompress backup: %v", err)
+		}
+	}
+
+	header, entries, err := keymate.ReadBackup(r)
+	if err != nil {
+		log.Fatalf("failed to read backup: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	unlock, err := mgr.Lock(ctx, header.Prefixes)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+	defer unlock()
+
+	replace, _ := cmd.Flags().GetBool("replace")
+	plan, err := mgr.PlanRestore(ctx, header, entries, replace)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+
+	cmd.Printf("INF: backup of %s taken at etcd revision %d\n", header.Time.Local().Format(time.DateTime), header.EtcdRevision)
+	printPlan(cmd, plan)
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		cmd.Print("dry run, nothing was changed\n")
+		return
+	}
+
+	err = mgr.ApplyPlan(ctx, plan)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+
+	cmd.Print("backup restored!\n")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBackupFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "backup.jsonl.gz")
+
+	err := writeBackupFile(filename, func(w io.Writer) error {
+		_, err := io.WriteString(w, "{}\n")
+		return err
+	})
+	require.NoError(t, err)
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	content, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n", string(content))
+
+	failed := filepath.Join(t.TempDir(), "failed.jsonl")
+	err = writeBackupFile(failed, func(w io.Writer) error {
+		io.WriteString(w, "{}\n")
+		return errors.New("connection lost")
+	})
+	assert.EqualError(t, err, "connection lost")
+	assert.NoFileExists(t, failed, "partial backups should be removed")
+}
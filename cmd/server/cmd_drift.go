@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "compares the store to the configuration and the saved state",
+	Long:  "compares the keys under the prefixes of the configuration to the ones it produces and to the saved state. Exits with 0 when the store matches, 2 when drift is found and 1 on errors.",
+	Run:   driftCmdRun,
+}
+
+// Exit code of drift when the store doesn't match the configuration
+const DRIFT_EXIT_CODE = 2
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+	driftCmd.PersistentFlags().Bool("json", false, "print the drift as JSON")
+	driftCmd.PersistentFlags().Bool("ignore-foreign", false, "don't report the keys that don't belong to any known target")
+}
+
+func driftCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	state, err := mgr.GetState(cmd.Context())
+	if err != nil {
+		log.Fatalf("failed to get state: %v", err)
+	}
+
+	plan, err := mgr.Plan(cmd.Context(), cfg, state)
+	if err != nil {
+		log.Fatalf("failed to compare the store: %v", err)
+	}
+
+	plan = plan.Redacted(cfg.Redact)
+
+	if ignore, _ := cmd.Flags().GetBool("ignore-foreign"); ignore {
+		plan.Foreign = nil
+	}
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			log.Fatalf("failed to encode drift: %v", err)
+		}
+	} else {
+		printDrift(plan)
+	}
+
+	if !plan.Empty() || len(plan.Foreign) > 0 {
+		os.Exit(DRIFT_EXIT_CODE)
+	}
+}
+
+func printDrift(plan *keymate.Plan) {
+	if plan.Empty() && len(plan.Foreign) == 0 {
+		log.Info("no drift found")
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Drift", "Target", "Key", "Store", "Configuration"})
+
+	for _, target := range plan.Removed {
+		t.AppendRow(table.Row{"removed", keymate.TargetKey(target), "", "", ""})
+	}
+
+	for _, c := range plan.Puts {
+		drift := "modified"
+		if c.Current == "" {
+			drift = "missing"
+		}
+
+		t.AppendRow(table.Row{drift, c.Target, c.Key, c.Current, c.Wanted})
+	}
+
+	for _, c := range plan.Deletes {
+		t.AppendRow(table.Row{"stale", c.Target, c.Key, c.Current, ""})
+	}
+
+	for _, key := range plan.Foreign {
+		t.AppendRow(table.Row{"foreign", "", key, "", ""})
+	}
+
+	t.Render()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "writes the keys of a prefix as traefik's dynamic configuration",
+	Long:  "reads every key of the prefix, including the ones traffikey doesn't manage, and writes them as traefik's dynamic configuration that the file provider can read.",
+	Run:   exportCmdRun,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.PersistentFlags().StringP("prefix", "p", keymate.TRAEFIK_DEFAULT_PREFIX, "etcd key prefix")
+	exportCmd.PersistentFlags().StringP("output", "o", "", "file to write (defaults to stdout)")
+	exportCmd.PersistentFlags().StringP("format", "f", "", "yaml, toml or json (defaults to the extension of the output or yaml)")
+}
+
+// encodeDynamicConfig writes traefik's dynamic configuration in the format
+func encodeDynamicConfig(cfg map[string]interface{}, format string) ([]byte, error) {
+	switch format {
+	case "yaml", "yml":
+		return traffikey.MarshalYAML(cfg)
+
+	case "toml":
+		buf := new(bytes.Buffer)
+		err := toml.NewEncoder(buf).Encode(cfg)
+		return buf.Bytes(), err
+
+	case "json":
+		b, err := json.MarshalIndent(cfg, "", "  ")
+		return append(b, '\n'), err
+	}
+
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+func exportCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+	prefix := cmd.Flag("prefix").Value.String()
+	output := cmd.Flag("output").Value.String()
+
+	format := cmd.Flag("format").Value.String()
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(output), ".")
+	}
+	if format == "" {
+		format = "yaml"
+	}
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	dynamic, err := mgr.Export(cmd.Context(), prefix)
+	if err != nil {
+		log.Fatalf("failed to export prefix %s: %v", prefix, err)
+	}
+
+	b, err := encodeDynamicConfig(dynamic, format)
+	if err != nil {
+		log.Fatalf("failed to encode configuration: %v", err)
+	}
+
+	if output == "" {
+		os.Stdout.Write(b)
+		return
+	}
+
+	err = os.WriteFile(output, b, 0o644)
+	if err != nil {
+		log.Fatalf("failed to write %s: %v", output, err)
+	}
+}
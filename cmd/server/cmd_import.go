@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "writes a configuration from the keys of a prefix",
+	Long:  "reads every router of the prefix with its service and middlewares and writes a configuration holding them as targets. The keys that applying the configuration wouldn't reproduce are reported.",
+	Run:   importCmdRun,
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.PersistentFlags().StringP("prefix", "p", keymate.TRAEFIK_DEFAULT_PREFIX, "etcd key prefix")
+	importCmd.PersistentFlags().StringP("output", "o", "", "file to write, as YAML when it ends with .yaml or .yml (defaults to JSON on stdout)")
+	importCmd.PersistentFlags().Bool("force", false, "overwrite the output file if it exists")
+}
+
+func importCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+	prefix := cmd.Flag("prefix").Value.String()
+	output := cmd.Flag("output").Value.String()
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	if force, _ := cmd.Flags().GetBool("force"); !force && output != "" {
+		if _, err := os.Stat(output); err == nil {
+			log.Fatalf("%s already exists, use --force to overwrite it", output)
+		}
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	imp, err := mgr.ImportTargets(cmd.Context(), prefix)
+	if err != nil {
+		log.Fatalf("failed to import targets: %v", err)
+	}
+
+	for _, problem := range imp.Problems {
+		log.Warn(problem)
+	}
+	for _, key := range imp.Unsupported {
+		log.WithField("key", key).Warn("key can't be represented and won't be applied")
+	}
+	for _, c := range imp.Changed {
+		// The values can hold credentials, like the users of basic auth middlewares
+		log.WithField("key", c.Key).Warn("key would be applied with another value")
+	}
+
+	// The prefix of the targets is the default one of the written configuration
+	for _, target := range imp.Targets {
+		target.Prefix = ""
+	}
+
+	// The connection to etcd is left out, it can hold credentials and the
+	// written configuration is meant to be committed
+	traefik := *cfg.Traefik
+	traefik.DefaultPrefix = prefix
+	imported := &traffikey.Config{
+		Targets: imp.Targets,
+		Traefik: &traefik,
+	}
+
+	var b []byte
+	if traffikey.IsYAML(output) {
+		b, err = traffikey.MarshalYAML(imported)
+	} else {
+		b, err = json.MarshalIndent(imported, "", "  ")
+		b = append(b, '\n')
+	}
+	if err != nil {
+		log.Fatalf("failed to encode configuration: %v", err)
+	}
+
+	if output == "" {
+		os.Stdout.Write(b)
+		return
+	}
+
+	err = os.WriteFile(output, b, 0o644)
+	if err != nil {
+		log.Fatalf("failed to write %s: %v", output, err)
+	}
+
+	log.Infof("%d targets of prefix %s written to %s", len(imp.Targets), prefix, output)
+}
@@ -13,13 +13,13 @@ import (
 
 var listCmd = &cobra.Command{
 	Use:   "list",
-	Short: "lists all targets for a prefix",
+	Short: "lists all targets of the prefixes",
 	Run:   listCmdRun,
 }
 
 func init() {
 	rootCmd.AddCommand(listCmd)
-	listCmd.PersistentFlags().StringP("prefix", "p", keymate.TRAEFIK_DEFAULT_PREFIX, "etcd key prefix")
+	listCmd.PersistentFlags().StringP("prefix", "p", "", "etcd key prefix (defaults to the prefixes of the configuration)")
 }
 
 // Take the argument from the command and look through matching keys in etcd
@@ -43,13 +43,25 @@ func listCmdRun(cmd *cobra.Command, args []string) {
 	t.SetOutputMirror(os.Stdout)
 	t.AppendHeader(table.Row{"Name", "Entrypoint", "Middleware", "Prefix", "Rule", "TLS"})
 
-	cfg.Traefik.DefaultPrefix = prefix
+	prefixes := cfg.Prefixes()
+	if prefix != "" {
+		prefixes = []string{prefix}
+	}
+
+	for _, prefix := range prefixes {
+		traefik := *cfg.Traefik
+		traefik.DefaultPrefix = prefix
+
+		targets, err := mgr.ListTargets(cmd.Context(), &traffikey.Config{Traefik: &traefik})
+		if err != nil {
+			log.Fatalf("failed to list targets of prefix %s: %v", prefix, err)
+		}
 
-	targets, err := mgr.ListTargets(cmd.Context(), cfg)
-	for _, target := range targets {
-		log.Debugf("Processing target %+v\n", target)
+		for _, target := range targets {
+			log.Debugf("Processing target %+v\n", target)
 
-		t.AppendRow(table.Row{target.Name, target.Entrypoint, len(target.Middlewares), target.Prefix, target.Rule, target.TLS})
+			t.AppendRow(table.Row{target.Name, target.Entrypoint, len(target.Middlewares), target.Prefix, target.Rule, target.TLS})
+		}
 	}
 
 	t.Render()
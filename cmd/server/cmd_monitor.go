@@ -11,6 +11,8 @@ import (
 	logrusmiddleware "github.com/numkem/echo-logrusmiddleware"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+
+	traffikey "github.com/numkem/traffikey"
 )
 
 var monitorCmd = &cobra.Command{
@@ -25,6 +27,8 @@ const DEFAULT_BIND_ADDRESS = "0.0.0.0:7865"
 func init() {
 	rootCmd.AddCommand(monitorCmd)
 	monitorCmd.PersistentFlags().StringP("bind", "b", DEFAULT_BIND_ADDRESS, "Binding address for the monitoring server")
+	monitorCmd.PersistentFlags().String("identity", "", "Identity of this instance in the leader election (defaults to the hostname)")
+	monitorCmd.PersistentFlags().Int("election-ttl", DEFAULT_ELECTION_TTL, "TTL in seconds of the leader's lease, followers take over once it expires")
 }
 
 func monitorCmdRun(cmd *cobra.Command, args []string) {
@@ -34,14 +38,32 @@ func monitorCmdRun(cmd *cobra.Command, args []string) {
 		log.Fatalf("failed to read configuration: %v", err)
 	}
 
+	if identity, _ := cmd.Flags().GetString("identity"); identity != "" {
+		mon.Identity = identity
+	}
+	mon.ElectionTTL, _ = cmd.Flags().GetInt("election-ttl")
+
 	h := &handler{monitor: mon}
 
+	auth := newAuthenticator(mon.Tokens)
+	if len(mon.Tokens()) == 0 {
+		log.Warn("no tokens are configured, only the status of the monitor is served")
+	}
+	read := auth.require(traffikey.VERB_READ)
+
 	// echo init
 	e := echo.New()
 	e.HideBanner = true
 	e.Logger = logrusmiddleware.Logger{Logger: log.StandardLogger()}
 
-	e.GET("/", h.List)
+	e.GET("/", h.Dashboard)
+	e.GET("/api/targets", h.List, read)
+	e.POST("/api/maintenance", h.Maintenance, auth.require(traffikey.VERB_MAINTENANCE))
+	e.GET("/status", h.Status, auth.status())
+	e.GET("/metrics", h.Metrics, read)
+	e.GET("/history", h.History, read)
+	e.GET("/history/*", h.TargetHistory, read)
+	e.GET("/events", h.Events, read)
 
 	go func() {
 		mon.Start()
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var monitorWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "prints the changes seen by a running monitor as they happen",
+	Run:   monitorWatchCmdRun,
+}
+
+const (
+	DEFAULT_MONITOR_URL = "http://127.0.0.1:7865"
+	MONITOR_TOKEN_ENV   = "TRAFFIKEY_TOKEN"
+
+	// Delay before reconnecting to the monitor when the stream ends
+	WATCH_RECONNECT_DELAY = 5 * time.Second
+)
+
+func init() {
+	monitorCmd.AddCommand(monitorWatchCmd)
+	monitorWatchCmd.Flags().StringP("url", "u", DEFAULT_MONITOR_URL, "URL of the monitoring server")
+	monitorWatchCmd.Flags().String("token", "", "Token allowed to read the monitor (defaults to $"+MONITOR_TOKEN_ENV+")")
+}
+
+func monitorWatchCmdRun(cmd *cobra.Command, args []string) {
+	url, _ := cmd.Flags().GetString("url")
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv(MONITOR_TOKEN_ENV)
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+"/events", nil)
+		if err != nil {
+			cmd.PrintErrf("ERR: invalid monitor URL: %v\n", err)
+			return
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		switch {
+		case err != nil:
+			cmd.PrintErrf("ERR: failed to connect to the monitor: %v\n", err)
+
+		case resp.StatusCode != http.StatusOK:
+			cmd.PrintErrf("ERR: monitor returned status %s\n", resp.Status)
+			resp.Body.Close()
+
+		default:
+			err = readEvents(resp.Body, func(ev *MonitorEvent) {
+				cmd.Printf("%s %s\n", ev.Time.Local().Format(time.DateTime), ev)
+			})
+			resp.Body.Close()
+
+			if err != nil && ctx.Err() == nil {
+				cmd.PrintErrf("ERR: event stream interrupted: %v\n", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(WATCH_RECONNECT_DELAY):
+		}
+	}
+}
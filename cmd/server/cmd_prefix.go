@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+var prefixCmd = &cobra.Command{
+	Use:   "prefix",
+	Short: "copies or moves targets between prefixes",
+}
+
+var prefixCopyCmd = &cobra.Command{
+	Use:   "copy <source> <destination>",
+	Short: "copies the keys and state of targets to another prefix",
+	Args:  cobra.ExactArgs(2),
+	Run:   func(cmd *cobra.Command, args []string) { prefixCopyCmdRun(cmd, args, false) },
+}
+
+var prefixMoveCmd = &cobra.Command{
+	Use:   "move <source> <destination>",
+	Short: "moves the keys and state of targets to another prefix",
+	Long:  "moves the keys and state of targets to another prefix. The keys of the destination are written and the ones of the source deleted in the same transaction.",
+	Args:  cobra.ExactArgs(2),
+	Run:   func(cmd *cobra.Command, args []string) { prefixCopyCmdRun(cmd, args, true) },
+}
+
+func init() {
+	rootCmd.AddCommand(prefixCmd)
+	prefixCmd.AddCommand(prefixCopyCmd)
+	prefixCmd.AddCommand(prefixMoveCmd)
+	prefixCmd.PersistentFlags().StringSlice("name", nil, "only the targets with these names")
+	prefixCmd.PersistentFlags().StringSlice("type", nil, "only the targets with these types")
+	prefixCmd.PersistentFlags().Bool("overwrite", false, "replace the keys of the destination that hold other values")
+	prefixCmd.PersistentFlags().Bool("dry-run", false, "show the changes without writing them")
+}
+
+func prefixCopyCmdRun(cmd *cobra.Command, args []string, move bool) {
+	configFilename := cmd.Flag("config").Value.String()
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		cmd.PrintErrf("ERR: failed to read configuration: %v\n", err)
+		return
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		cmd.PrintErrf("ERR: failed to create manager: %v\n", err)
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	c := &keymate.PrefixCopy{Source: args[0], Destination: args[1], Move: move}
+	c.Names, _ = cmd.Flags().GetStringSlice("name")
+	c.Types, _ = cmd.Flags().GetStringSlice("type")
+	c.Overwrite, _ = cmd.Flags().GetBool("overwrite")
+
+	unlock, err := mgr.Lock(ctx, []string{c.Source, c.Destination})
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+	defer unlock()
+
+	plan, err := mgr.PlanPrefixCopy(ctx, c)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+
+	for _, target := range plan.Targets {
+		cmd.Printf("INF: target %s of the state now in prefix %s\n", target, c.Destination)
+	}
+	printPlan(cmd, plan.Plan)
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		cmd.Print("dry run, nothing was changed\n")
+		return
+	}
+
+	err = mgr.ApplyPrefixCopy(ctx, plan)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+
+	// The next apply would revert the change otherwise
+	if len(plan.Targets) > 0 && move {
+		cmd.Printf("WARN: set the prefix of the moved targets to %s in the configuration file\n", c.Destination)
+	} else if len(plan.Targets) > 0 {
+		cmd.Printf("WARN: add the copied targets with the prefix %s to the configuration file\n", c.Destination)
+	}
+
+	if move {
+		cmd.Print("targets moved!\n")
+	} else {
+		cmd.Print("targets copied!\n")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/numkem/traffikey"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render [name...]",
+	Short: "shows the targets of the configuration once expanded",
+	Long:  "shows each target of the configuration, or the named ones, after the templates it extends, its prefixes and the defaults of the traefik and monitor sections are applied. Secrets read from the environment or files are shown as their ${...} expression.",
+	Run:   renderCmdRun,
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+	renderCmd.PersistentFlags().StringP("format", "f", "yaml", "yaml or json")
+}
+
+// renderTargets returns the targets of the configuration with their defaults
+// filled, only the named ones when names are given
+func renderTargets(cfg *traffikey.Config, names []string) []*traffikey.Target {
+	var targets []*traffikey.Target
+	for i, tgt := range cfg.Redacted().Targets {
+		if len(names) > 0 && !slices.Contains(names, tgt.Name) {
+			continue
+		}
+
+		// The copy made to redact the secrets doesn't keep where targets come from
+		tgt.Source = cfg.Targets[i].Source
+
+		normalizeTarget(cfg, tgt)
+		if tgt.Monitored {
+			tgt.HealthCheck = tgt.HealthCheck.Merge(cfg.Monitor.HealthCheck)
+		}
+
+		targets = append(targets, tgt)
+	}
+
+	return targets
+}
+
+// encodeTargets writes the targets in the format, YAML ones with where they come from as comments
+func encodeTargets(targets []*traffikey.Target, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(targets, "", "  ")
+		return append(b, '\n'), err
+
+	case "yaml", "yml":
+		buf := new(bytes.Buffer)
+		for _, tgt := range targets {
+			if tgt.Source != "" {
+				fmt.Fprintf(buf, "# %s\n", tgt.Source)
+			}
+
+			b, err := traffikey.MarshalYAML([]*traffikey.Target{tgt})
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(b)
+		}
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown format %s", format)
+}
+
+func renderCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+	format := cmd.Flag("format").Value.String()
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	targets := renderTargets(cfg, args)
+	if len(args) > 0 && len(targets) == 0 {
+		log.Fatalf("no target named %v in the configuration", args)
+	}
+
+	b, err := encodeTargets(targets, format)
+	if err != nil {
+		log.Fatalf("failed to encode targets: %v", err)
+	}
+
+	os.Stdout.Write(b)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "lists the revisions of the state saved by each apply",
+	Run:   historyCmdRun,
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <revision> <revision>",
+	Short: "shows the keys that changed between two revisions",
+	Args:  cobra.ExactArgs(2),
+	Run:   diffCmdRun,
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <revision>",
+	Short: "applies the configuration of an earlier revision",
+	Long:  "applies the configuration of an earlier revision the same way apply does. It is saved as a new revision.",
+	Args:  cobra.ExactArgs(1),
+	Run:   rollbackCmdRun,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.PersistentFlags().Bool("dry-run", false, "show the changes without writing them")
+}
+
+func revisionManager(cmd *cobra.Command) keymate.KeymateConnector {
+	configFilename := cmd.Flag("config").Value.String()
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	return mgr
+}
+
+// getRevision returns the revision numbered by the argument
+func getRevision(cmd *cobra.Command, mgr keymate.KeymateConnector, arg string) *traffikey.Revision {
+	number, err := strconv.Atoi(arg)
+	if err != nil {
+		log.Fatalf("invalid revision %s: %v", arg, err)
+	}
+
+	rev, err := mgr.GetRevision(cmd.Context(), number)
+	if err != nil {
+		log.Fatalf("failed to get revision: %v", err)
+	}
+	if rev == nil {
+		log.Fatalf("revision %d doesn't exist", number)
+	}
+
+	return rev
+}
+
+func historyCmdRun(cmd *cobra.Command, args []string) {
+	mgr := revisionManager(cmd)
+
+	revisions, err := mgr.ListRevisions(cmd.Context())
+	if err != nil {
+		log.Fatalf("failed to get revisions: %v", err)
+	}
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Revision", "Time", "Author", "Targets", "Keys", "Etcd revision"})
+
+	for _, rev := range revisions {
+		t.AppendRow(table.Row{rev.Number, rev.Time.Local().Format(time.DateTime), rev.Author, len(rev.Config.Targets), len(rev.Keys), rev.EtcdRevision})
+	}
+
+	t.Render()
+}
+
+func diffCmdRun(cmd *cobra.Command, args []string) {
+	mgr := revisionManager(cmd)
+
+	from := getRevision(cmd, mgr, args[0])
+	to := getRevision(cmd, mgr, args[1])
+
+	cmd.Printf("--- revision %d (%s by %s)\n", from.Number, from.Time.Local().Format(time.DateTime), from.Author)
+	cmd.Printf("+++ revision %d (%s by %s)\n", to.Number, to.Time.Local().Format(time.DateTime), to.Author)
+
+	diff := from.DiffKeys(to)
+	for _, key := range diff.Added {
+		cmd.Printf("+ %s = %s\n", key, to.Keys[key])
+	}
+	for _, key := range diff.Changed {
+		cmd.Printf("~ %s = %s (was %s)\n", key, to.Keys[key], from.Keys[key])
+	}
+	for _, key := range diff.Removed {
+		cmd.Printf("- %s = %s\n", key, from.Keys[key])
+	}
+}
+
+func rollbackCmdRun(cmd *cobra.Command, args []string) {
+	mgr := revisionManager(cmd)
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	rev := getRevision(cmd, mgr, args[0])
+
+	// Revisions are saved with the ${...} expressions of their secrets
+	err := rev.Config.Interpolate()
+	if err != nil {
+		cmd.PrintErrf("ERR: failed to interpolate revision %d: %v\n", rev.Number, err)
+		return
+	}
+
+	unlock, err := lockState(ctx, mgr, rev.Config)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+	defer unlock()
+
+	state, err := mgr.GetState(ctx)
+	if err != nil {
+		cmd.PrintErrf("ERR: failed to get current state: %v\n", err)
+		return
+	}
+
+	plan, err := mgr.Plan(ctx, rev.Config, state)
+	if err != nil {
+		cmd.PrintErrf("ERR: failed to compare revision %d to the store: %v\n", rev.Number, err)
+		return
+	}
+
+	printPlan(cmd, plan.Redacted(rev.Config.Redact))
+
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		cmd.Print("dry run, nothing was changed\n")
+		return
+	}
+
+	err = mgr.ApplyPlan(ctx, plan)
+	if err != nil {
+		cmd.PrintErrf("ERR: %v\n", err)
+		return
+	}
+
+	err = mgr.SaveState(ctx, rev.Config)
+	if err != nil {
+		cmd.PrintErrf("ERR: failed to write state: %v\n", err)
+		return
+	}
+
+	cmd.Printf("rolled back to revision %d!\n", rev.Number)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	logrusmiddleware "github.com/numkem/echo-logrusmiddleware"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	traffikey "github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve an API to manage the targets of the store",
+	Long:  "serve an authenticated REST API to create, update and delete targets, along with a gRPC API mirroring the operations on the store. The OpenAPI document of the REST API is served at /openapi.json",
+	Run:   serveCmdRun,
+}
+
+const (
+	DEFAULT_API_BIND_ADDRESS  = "0.0.0.0:7866"
+	DEFAULT_GRPC_BIND_ADDRESS = "0.0.0.0:7867"
+)
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.PersistentFlags().StringP("bind", "b", DEFAULT_API_BIND_ADDRESS, "Binding address for the API server")
+	serveCmd.PersistentFlags().String("grpc-bind", DEFAULT_GRPC_BIND_ADDRESS, "Binding address for the gRPC server, empty to disable it")
+	serveCmd.PersistentFlags().String("grpc-tls-cert", "", "Certificate file to serve gRPC over TLS")
+	serveCmd.PersistentFlags().String("grpc-tls-key", "", "Key file of the gRPC certificate")
+}
+
+// grpcServerOptions returns the options serving gRPC over TLS with the
+// certificate and key, none when both are empty
+func grpcServerOptions(certFile, keyFile string) ([]grpc.ServerOption, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both --grpc-tls-cert and --grpc-tls-key are required to serve gRPC over TLS")
+	}
+
+	creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the gRPC certificate: %v", err)
+	}
+
+	return []grpc.ServerOption{grpc.Creds(creds)}, nil
+}
+
+// newAPIServer returns an echo server exposing the API under /api/v1 behind the tokens
+func newAPIServer(s *apiServer, auth *authenticator) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.Logger = logrusmiddleware.Logger{Logger: log.StandardLogger()}
+
+	document := openAPIDocument(apiRoutes)
+	document["servers"] = []interface{}{map[string]interface{}{"url": "/api/v1"}}
+	e.GET("/openapi.json", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, document)
+	})
+
+	s.register(e.Group("/api/v1"), auth)
+
+	return e
+}
+
+func serveCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("failed to read configuration: %v", err)
+	}
+
+	if len(cfg.Tokens) == 0 {
+		log.Fatalf("no tokens are configured, add some with `traffikey token generate`")
+	}
+
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	auth := newAuthenticator(func() map[string]*traffikey.TokenConfig { return cfg.Tokens })
+	e := newAPIServer(&apiServer{manager: mgr, cfg: cfg}, auth)
+
+	certFile, _ := cmd.Flags().GetString("grpc-tls-cert")
+	keyFile, _ := cmd.Flags().GetString("grpc-tls-key")
+	opts, err := grpcServerOptions(certFile, keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := newGRPCServer(&grpcServer{manager: mgr, cfg: cfg, auth: auth}, opts...)
+
+	if grpcBind, _ := cmd.Flags().GetString("grpc-bind"); grpcBind != "" {
+		if len(opts) == 0 {
+			log.Warn("the gRPC API is served without TLS, tokens are sent in clear: use --grpc-tls-cert and --grpc-tls-key")
+		}
+
+		lis, err := net.Listen("tcp", grpcBind)
+		if err != nil {
+			log.Fatalf("failed to listen for gRPC: %v", err)
+		}
+
+		go func() {
+			err := g.Serve(lis)
+			if err != nil {
+				log.Fatalf("failed to serve the gRPC API: %v", err)
+			}
+		}()
+	}
+
+	go func() {
+		err := e.Start(fmt.Sprintf("%s", cmd.Flag("bind").Value))
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to serve the API: %v", err)
+		}
+	}()
+
+	// Listen for signal and quit
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), 2*time.Second)
+	defer cancel()
+
+	e.Shutdown(ctx)
+
+	// Streams are only cut once the delay is over
+	stopped := make(chan struct{})
+	go func() {
+		g.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		g.Stop()
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/numkem/traffikey"
+
+	"github.com/spf13/cobra"
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "manage the tokens of the HTTP APIs",
+}
+
+var tokenGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "generates a random token and prints the configuration to allow it",
+	Run:   tokenGenerateCmdRun,
+}
+
+var tokenHashCmd = &cobra.Command{
+	Use:   "hash",
+	Short: "prints the hash of the token read from the standard input",
+	Run:   tokenHashCmdRun,
+}
+
+// Number of random bytes of generated tokens
+const TOKEN_SIZE = 32
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenGenerateCmd, tokenHashCmd)
+
+	tokenGenerateCmd.Flags().StringSlice("prefixes", nil, "Prefixes the token is allowed to use, all of them if empty")
+	tokenGenerateCmd.Flags().StringSlice("types", nil, "Router types the token is allowed to use, all of them if empty")
+	tokenGenerateCmd.Flags().StringSlice("verbs", []string{traffikey.VERB_READ}, "Verbs allowed to the token: read, write and maintenance")
+}
+
+func tokenGenerateCmdRun(cmd *cobra.Command, args []string) {
+	b := make([]byte, TOKEN_SIZE)
+	_, err := rand.Read(b)
+	if err != nil {
+		cmd.PrintErrf("ERR: failed to generate token: %v\n", err)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	cfg := &traffikey.TokenConfig{Hash: traffikey.HashToken(token)}
+	cfg.Prefixes, _ = cmd.Flags().GetStringSlice("prefixes")
+	cfg.Types, _ = cmd.Flags().GetStringSlice("types")
+	cfg.Verbs, _ = cmd.Flags().GetStringSlice("verbs")
+
+	j, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		cmd.PrintErrf("ERR: failed to marshal token: %v\n", err)
+		return
+	}
+
+	cmd.Printf("Token (shown only once): %s\n\nAdd it to the \"tokens\" of the configuration:\n%s\n", token, j)
+}
+
+func tokenHashCmdRun(cmd *cobra.Command, args []string) {
+	// Read errors, like EOF without a newline, only matter when nothing was read
+	token, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	token = strings.TrimSpace(token)
+	if token == "" {
+		cmd.PrintErrf("ERR: no token read from the standard input\n")
+		return
+	}
+
+	cmd.Println(traffikey.HashToken(token))
+}
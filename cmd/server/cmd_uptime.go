@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var uptimeCmd = &cobra.Command{
+	Use:   "uptime [target key]",
+	Short: "shows the uptime of the monitored targets",
+	Long:  "shows the uptime of the monitored targets over the last 24h, 7d and 30d. When a target key (prefix/type/name) is given, its incidents are shown too",
+	Args:  cobra.MaximumNArgs(1),
+	Run:   uptimeCmdRun,
+}
+
+func init() {
+	rootCmd.AddCommand(uptimeCmd)
+}
+
+func formatUptime(uptime map[string]float64, window string) string {
+	v, ok := uptime[window]
+	if !ok {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.2f%%", v)
+}
+
+func uptimeCmdRun(cmd *cobra.Command, args []string) {
+	configFilename := cmd.Flag("config").Value.String()
+
+	cfg, err := traffikey.NewConfig(configFilename)
+	if err != nil {
+		log.Fatalf("Failed to read configuraiton: %v", err)
+	}
+
+	// Create manager connection
+	mgr, err := keymate.NewEtcdManager(cfg)
+	if err != nil {
+		log.Fatalf("failed to create manager: %v", err)
+	}
+
+	histories, err := mgr.ListHistories(cmd.Context())
+	if err != nil {
+		log.Fatalf("failed to get histories: %v", err)
+	}
+
+	now := time.Now()
+
+	t := table.NewWriter()
+	t.SetStyle(table.StyleLight)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Target", "Server", "24h", "7d", "30d"})
+
+	var selected *traffikey.TargetHistory
+	for _, history := range histories {
+		if len(args) > 0 && history.Key != args[0] {
+			continue
+		}
+		selected = history
+
+		report := newHistoryReport(history, now)
+		t.AppendRow(table.Row{report.Key, "", formatUptime(report.Uptime, "24h"), formatUptime(report.Uptime, "7d"), formatUptime(report.Uptime, "30d")})
+		for _, server := range report.Servers {
+			t.AppendRow(table.Row{"", server.Server, formatUptime(server.Uptime, "24h"), formatUptime(server.Uptime, "7d"), formatUptime(server.Uptime, "30d")})
+		}
+	}
+
+	t.Render()
+
+	if len(args) == 0 {
+		return
+	}
+	if selected == nil {
+		log.Fatalf("no history found for target %s", args[0])
+	}
+
+	incidents := table.NewWriter()
+	incidents.SetStyle(table.StyleLight)
+	incidents.SetOutputMirror(os.Stdout)
+	incidents.AppendHeader(table.Row{"Down since", "Up since", "Duration"})
+	for _, incident := range selected.Incidents() {
+		end := "still down"
+		if !incident.End.IsZero() {
+			end = incident.End.Local().Format(time.DateTime)
+		}
+
+		incidents.AppendRow(table.Row{incident.Start.Local().Format(time.DateTime), end, incident.Duration(now).Round(time.Second)})
+	}
+
+	incidents.Render()
+}
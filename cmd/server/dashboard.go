@@ -0,0 +1,176 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+
+	traffikey "github.com/numkem/traffikey"
+)
+
+// Number of incidents of each target shown in the dashboard
+const DASHBOARD_INCIDENTS = 5
+
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+// dashboardMiddleware leaves out the values of the middleware, which hold
+// credentials like basicauth hashes or forward-auth secrets
+type dashboardMiddleware struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type dashboardTarget struct {
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`
+	Prefix      string                 `json:"prefix"`
+	Rule        string                 `json:"rule"`
+	TLS         bool                   `json:"tls"`
+	Middlewares []*dashboardMiddleware `json:"middlewares"`
+	Key         string                 `json:"key"`
+	// Missing for targets that aren't monitored
+	State            *TargetState          `json:"state"`
+	Maintenance      bool                  `json:"maintenance"`
+	MaintenanceSince *time.Time            `json:"maintenance_since,omitempty"`
+	Uptime           map[string]float64    `json:"uptime"`
+	Incidents        []*traffikey.Incident `json:"incidents"`
+}
+
+type dashboardResponse struct {
+	statusResponse
+	Targets []*dashboardTarget `json:"targets"`
+}
+
+func (h *handler) Dashboard(c echo.Context) error {
+	return c.HTMLBlob(http.StatusOK, dashboardHTML)
+}
+
+// prefixes returns the prefixes used by the configured targets
+func (m *Monitor) prefixes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cfg.Prefixes()
+}
+
+// List returns every target found in the store along with its monitoring state
+func (h *handler) List(c echo.Context) error {
+	ctx := c.Request().Context()
+	now := time.Now()
+
+	states := make(map[string]*TargetState)
+	for _, state := range h.monitor.States() {
+		states[state.Key] = state
+	}
+
+	histories := make(map[string]*traffikey.TargetHistory)
+	for _, history := range h.monitor.Histories() {
+		histories[history.Key] = history
+	}
+
+	maintenance, err := h.monitor.manager.ListMaintenance(ctx)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	resp := &dashboardResponse{Targets: []*dashboardTarget{}}
+	resp.Identity = h.monitor.Identity
+	resp.Leader = h.monitor.IsLeader()
+	resp.CurrentLeader, err = h.monitor.manager.Leader(ctx, MONITOR_ELECTION)
+	if err != nil {
+		log.Warnf("failed to get the current monitor leader: %v", err)
+	}
+
+	for _, prefix := range h.monitor.prefixes() {
+		traefik := *h.monitor.cfg.Traefik
+		traefik.DefaultPrefix = prefix
+
+		targets, err := h.monitor.manager.ListTargets(ctx, &traffikey.Config{Traefik: &traefik})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+
+		for _, tgt := range targets {
+			if !allowed(c, traffikey.VERB_READ, tgt.Prefix, tgt.Type) {
+				continue
+			}
+
+			key := fmt.Sprintf("%s/%s/%s", tgt.Prefix, tgt.Type, tgt.Name)
+			dt := &dashboardTarget{
+				Name:        tgt.Name,
+				Type:        tgt.Type,
+				Prefix:      tgt.Prefix,
+				Rule:        tgt.Rule,
+				TLS:         tgt.TLS,
+				Middlewares: []*dashboardMiddleware{},
+				Key:         key,
+				State:       states[key],
+				Uptime:      map[string]float64{},
+				Incidents:   []*traffikey.Incident{},
+			}
+			for _, mw := range tgt.Middlewares {
+				dt.Middlewares = append(dt.Middlewares, &dashboardMiddleware{Name: mw.Name, Kind: mw.Kind})
+			}
+
+			if since, ok := maintenance[key]; ok {
+				dt.Maintenance = true
+				dt.MaintenanceSince = &since
+			}
+
+			if history, ok := histories[key]; ok {
+				dt.Uptime = uptimeByWindow(history, "", now)
+
+				incidents := history.Incidents()
+				if len(incidents) > DASHBOARD_INCIDENTS {
+					incidents = incidents[len(incidents)-DASHBOARD_INCIDENTS:]
+				}
+				dt.Incidents = incidents
+			}
+
+			resp.Targets = append(resp.Targets, dt)
+		}
+	}
+
+	sort.Slice(resp.Targets, func(i, j int) bool { return resp.Targets[i].Key < resp.Targets[j].Key })
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+type maintenanceRequest struct {
+	Key     string `json:"key"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Maintenance puts a target in or out of maintenance
+func (h *handler) Maintenance(c echo.Context) error {
+	req := new(maintenanceRequest)
+	err := c.Bind(req)
+	if err != nil || req.Key == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "a target key is required")
+	}
+	if _, _, ok := splitTargetKey(req.Key); !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "the target key should be <prefix>/<type>/<name>")
+	}
+
+	if !allowedKey(c, traffikey.VERB_MAINTENANCE, req.Key) {
+		return echo.NewHTTPError(http.StatusForbidden, "token isn't allowed to change the maintenance of this target")
+	}
+
+	err = h.monitor.SetMaintenance(c.Request().Context(), req.Key, req.Enabled)
+	if errors.Is(err, errNotLeader) {
+		leader, _ := h.monitor.manager.Leader(c.Request().Context(), MONITOR_ELECTION)
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("%v, use the leader %s", err, leader))
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/numkem/traffikey"
+)
+
+func TestDashboard(t *testing.T) {
+	m := testMonitor(t)
+	defer m.Stop()
+
+	e := echo.New()
+	h := &handler{monitor: m}
+	e.GET("/", h.Dashboard)
+	e.POST("/api/maintenance", h.Maintenance)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<title>Traffikey</title>")
+
+	// Followers can't change the maintenance of targets
+	req := httptest.NewRequest(http.MethodPost, "/api/maintenance", strings.NewReader(`{"key": "traefik/http/a", "enabled": true}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "use the leader leader-host")
+}
+
+func TestDashboardTokens(t *testing.T) {
+	m := testMonitor(t)
+	defer m.Stop()
+	m.cfg.Tokens = map[string]*traffikey.TokenConfig{
+		"reader": {Hash: traffikey.HashToken("reader"), Verbs: []string{traffikey.VERB_READ}},
+		"ops":    {Hash: traffikey.HashToken("ops"), Prefixes: []string{"traefik"}, Verbs: []string{traffikey.VERB_MAINTENANCE}},
+	}
+
+	e := echo.New()
+	h := &handler{monitor: m}
+	auth := newAuthenticator(m.Tokens)
+	e.GET("/status", h.Status, auth.status())
+	e.POST("/api/maintenance", h.Maintenance, auth.require(traffikey.VERB_MAINTENANCE))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "missing token")
+
+	// Tokens in the query would end up in the logs
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status?token=reader", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer reader")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	maintenance := func(token, key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/maintenance", strings.NewReader(`{"key": "`+key+`", "enabled": true}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusForbidden, maintenance("reader", "traefik/http/a"))
+	assert.Equal(t, http.StatusForbidden, maintenance("ops", "other/http/a"))
+	// Allowed, but only the leader can change the maintenance
+	assert.Equal(t, http.StatusConflict, maintenance("ops", "traefik/http/a"))
+}
+
+func TestDashboardWithoutTokens(t *testing.T) {
+	m := testMonitor(t)
+	defer m.Stop()
+
+	e := echo.New()
+	h := &handler{monitor: m}
+	auth := newAuthenticator(m.Tokens)
+	e.GET("/status", h.Status, auth.status())
+	e.GET("/api/targets", h.List, auth.require(traffikey.VERB_READ))
+	e.POST("/api/maintenance", h.Maintenance, auth.require(traffikey.VERB_MAINTENANCE))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Only the status is served without tokens
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/targets", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/maintenance", strings.NewReader(`{"key": "traefik/http/a", "enabled": true}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+// dashboardManager lists a target with a basicauth middleware
+type dashboardManager struct {
+	fakeManager
+}
+
+func (f *dashboardManager) ListTargets(ctx context.Context, cfg *traffikey.Config) ([]*traffikey.Target, error) {
+	return []*traffikey.Target{{
+		Name:        "web",
+		Type:        "http",
+		Prefix:      cfg.Traefik.DefaultPrefix,
+		Rule:        "Host(`web`)",
+		Middlewares: []*traffikey.Middleware{{Name: "auth", Kind: "basicauth", Values: map[string]string{"users": "admin:$apr1$secret"}}},
+	}}, nil
+}
+
+func (f *dashboardManager) ListMaintenance(ctx context.Context) (map[string]time.Time, error) {
+	return map[string]time.Time{}, nil
+}
+
+func TestDashboardList(t *testing.T) {
+	m := testMonitor(t)
+	defer m.Stop()
+	m.manager = &dashboardManager{}
+
+	e := echo.New()
+	h := &handler{monitor: m}
+	e.GET("/api/targets", h.List)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/targets", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	resp := new(dashboardResponse)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), resp))
+	require.Len(t, resp.Targets, 1)
+	assert.Equal(t, []*dashboardMiddleware{{Name: "auth", Kind: "basicauth"}}, resp.Targets[0].Middlewares)
+	assert.NotContains(t, rec.Body.String(), "secret", "the values of the middlewares should be left out")
+}
+
+// maintenanceManager records the prefixes locked to change the maintenance
+type maintenanceManager struct {
+	fakeManager
+	locked []string
+}
+
+func (f *maintenanceManager) Lock(ctx context.Context, prefixes []string) (func(), error) {
+	f.locked = append(f.locked, prefixes...)
+	return func() {}, nil
+}
+
+func (f *maintenanceManager) SetMaintenance(ctx context.Context, key string, enabled bool) error {
+	return nil
+}
+
+func TestSetMaintenancePrefix(t *testing.T) {
+	m := testMonitor(t)
+	defer m.Stop()
+	manager := &maintenanceManager{}
+	m.manager = manager
+	m.leader.Store(true)
+
+	require.NoError(t, m.SetMaintenance(context.Background(), "edge/traefik/http/web", true))
+	assert.Equal(t, []string{"edge/traefik"}, manager.locked, "the whole prefix should be locked")
+	assert.True(t, m.InMaintenance("edge/traefik/http/web"))
+
+	assert.Error(t, m.SetMaintenance(context.Background(), "web", true))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+
+	traffikey "github.com/numkem/traffikey"
+)
+
+const (
+	EVENT_TARGET = "target"
+	EVENT_SERVER = "server"
+	EVENT_APPLY  = "apply"
+	// Up is false when the target enters maintenance
+	EVENT_MAINTENANCE = "maintenance"
+
+	// Events waiting to be sent to a subscriber before new ones are dropped
+	EVENT_BUFFER_SIZE = 64
+	// Delay between two comments sent to keep the event stream open
+	EVENT_HEARTBEAT_INTERVAL = 15 * time.Second
+)
+
+// MonitorEvent is a change seen by the monitor
+type MonitorEvent struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Key     string    `json:"key,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Server  string    `json:"server,omitempty"`
+	Up      bool      `json:"up"`
+	Message string    `json:"message,omitempty"`
+}
+
+func (e *MonitorEvent) String() string {
+	state := "DOWN"
+	if e.Up {
+		state = "UP"
+	}
+
+	switch e.Type {
+	case EVENT_TARGET:
+		return fmt.Sprintf("target %s is %s", e.Key, state)
+	case EVENT_SERVER:
+		return fmt.Sprintf("server %s of target %s is %s", e.Server, e.Key, state)
+	case EVENT_MAINTENANCE:
+		if e.Up {
+			return fmt.Sprintf("target %s is out of maintenance", e.Key)
+		}
+		return fmt.Sprintf("target %s is in maintenance", e.Key)
+	default:
+		return e.Message
+	}
+}
+
+// eventBus sends the events published by the monitor to every subscriber
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan *MonitorEvent]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan *MonitorEvent]struct{})}
+}
+
+func (b *eventBus) Subscribe() chan *MonitorEvent {
+	ch := make(chan *MonitorEvent, EVENT_BUFFER_SIZE)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch
+}
+
+func (b *eventBus) Unsubscribe(ch chan *MonitorEvent) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+func (b *eventBus) Publish(ev *MonitorEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			log.Warn("event subscriber is too slow, dropping event")
+		}
+	}
+}
+
+// Events streams the monitor events as server-sent events
+func (h *handler) Events(c echo.Context) error {
+	events := h.monitor.events.Subscribe()
+	defer h.monitor.events.Unsubscribe(events)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	heartbeat := time.NewTicker(EVENT_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			w.Flush()
+
+		case ev := <-events:
+			if ev.Key != "" && !allowedKey(c, traffikey.VERB_READ, ev.Key) {
+				continue
+			}
+
+			j, err := json.Marshal(ev)
+			if err != nil {
+				return fmt.Errorf("failed to marshal event: %v", err)
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, j)
+			w.Flush()
+		}
+	}
+}
+
+// readEvents decodes a stream of server-sent events until it ends
+func readEvents(r io.Reader, fn func(ev *MonitorEvent)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+
+		ev := new(MonitorEvent)
+		err := json.Unmarshal([]byte(data), ev)
+		if err != nil {
+			return fmt.Errorf("failed to decode event: %v", err)
+		}
+
+		fn(ev)
+	}
+
+	return scanner.Err()
+}
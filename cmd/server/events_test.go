@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventsStream(t *testing.T) {
+	m := testMonitor(t)
+	defer m.Stop()
+
+	e := echo.New()
+	h := &handler{monitor: m}
+	e.GET("/events", h.Events)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The subscription is done once the headers are sent
+	m.events.Publish(&MonitorEvent{Type: EVENT_TARGET, Key: "traefik/http/a", Target: "a"})
+
+	received := make(chan *MonitorEvent, 1)
+	go readEvents(resp.Body, func(ev *MonitorEvent) { received <- ev })
+
+	select {
+	case ev := <-received:
+		assert.Equal(t, "target traefik/http/a is DOWN", ev.String())
+	case <-ctx.Done():
+		t.Fatal("no event received")
+	}
+}
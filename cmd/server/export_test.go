@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDynamicConfig(t *testing.T) {
+	cfg := map[string]interface{}{
+		"http": map[string]interface{}{
+			"services": map[string]interface{}{
+				"web": map[string]interface{}{
+					"loadBalancer": map[string]interface{}{
+						"servers": []interface{}{map[string]interface{}{"url": "http://10.0.0.1"}},
+					},
+				},
+			},
+		},
+	}
+
+	b, err := encodeDynamicConfig(cfg, "toml")
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "[[http.services.web.loadBalancer.servers]]")
+	assert.Contains(t, string(b), `url = "http://10.0.0.1"`)
+
+	b, err = encodeDynamicConfig(cfg, "yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "- url: http://10.0.0.1")
+
+	_, err = encodeDynamicConfig(cfg, "xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	traffikey "github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/api"
+	"github.com/numkem/traffikey/keymate"
+)
+
+// Verbs a token must be allowed to call the methods of the gRPC API
+var grpcVerbs = map[string]string{
+	api.Traffikey_ApplyConfig_FullMethodName:  traffikey.VERB_WRITE,
+	api.Traffikey_ApplyTarget_FullMethodName:  traffikey.VERB_WRITE,
+	api.Traffikey_DeleteTarget_FullMethodName: traffikey.VERB_WRITE,
+	api.Traffikey_ListTargets_FullMethodName:  traffikey.VERB_READ,
+	api.Traffikey_GetState_FullMethodName:     traffikey.VERB_READ,
+	api.Traffikey_SaveState_FullMethodName:    traffikey.VERB_WRITE,
+	api.Traffikey_WatchState_FullMethodName:   traffikey.VERB_READ,
+}
+
+type grpcAuthorizationKey struct{}
+
+// grpcServer exposes the operations of the manager through gRPC
+type grpcServer struct {
+	api.UnimplementedTraffikeyServer
+
+	manager keymate.KeymateConnector
+	cfg     *traffikey.Config
+	auth    *authenticator
+}
+
+func newGRPCServer(s *grpcServer, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.UnaryInterceptor(s.unaryInterceptor),
+		grpc.StreamInterceptor(s.streamInterceptor),
+	)
+	server := grpc.NewServer(opts...)
+	api.RegisterTraffikeyServer(server, s)
+
+	return server
+}
+
+func grpcAuditFields(ctx context.Context, name, method string) log.Fields {
+	fields := log.Fields{"token": name, "verb": grpcVerbs[method], "method": method}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields["remote"] = p.Addr.String()
+	}
+
+	return fields
+}
+
+// authorize checks the token of the call and adds it to the context. Every
+// call is refused when no tokens are configured.
+func (s *grpcServer) authorize(ctx context.Context, method string) (context.Context, *authorization, error) {
+	if s.auth.closed() {
+		log.WithFields(grpcAuditFields(ctx, "", method)).Warn("call denied, no tokens are configured")
+		return nil, nil, status.Errorf(codes.PermissionDenied, "no tokens are configured, %s isn't allowed", grpcVerbs[method])
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, nil, status.Error(codes.Unauthenticated, "missing token")
+	}
+
+	auth := s.auth.lookup(strings.TrimPrefix(values[0], "Bearer "))
+	if auth == nil {
+		log.WithFields(grpcAuditFields(ctx, "", method)).Warn("call with an unknown token denied")
+		return nil, nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	verb := grpcVerbs[method]
+	if !auth.Token.Allows(verb, "", "") {
+		log.WithFields(grpcAuditFields(ctx, auth.Name, method)).Warn("call denied")
+		return nil, nil, status.Errorf(codes.PermissionDenied, "token %s isn't allowed to %s", auth.Name, verb)
+	}
+
+	ctx = keymate.WithAuthor(ctx, tokenAuthor(auth.Name))
+	return context.WithValue(ctx, grpcAuthorizationKey{}, auth), auth, nil
+}
+
+func (s *grpcServer) audit(ctx context.Context, auth *authorization, method string, err error) {
+	if auth == nil {
+		return
+	}
+
+	entry := log.WithFields(grpcAuditFields(ctx, auth.Name, method)).WithField("code", status.Code(err).String())
+	if grpcVerbs[method] == traffikey.VERB_READ {
+		entry.Debug("call")
+	} else {
+		entry.Info("call")
+	}
+}
+
+func (s *grpcServer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, auth, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := handler(ctx, req)
+	s.audit(ctx, auth, info.FullMethod, err)
+
+	return resp, err
+}
+
+// authorizedStream replaces the context of the stream by the one holding the token
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *grpcServer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, auth, err := s.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+
+	err = handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+	s.audit(ctx, auth, info.FullMethod, err)
+
+	return err
+}
+
+// grpcAllowed returns whether the token of the call allows the verb on the prefix and type
+func grpcAllowed(ctx context.Context, verb, prefix, routerType string) bool {
+	auth, _ := ctx.Value(grpcAuthorizationKey{}).(*authorization)
+	if auth == nil {
+		return true
+	}
+
+	return auth.Token.Allows(verb, prefix, routerType)
+}
+
+// checkTarget fills the defaults of the target and checks that the token can write it
+func (s *grpcServer) checkTarget(ctx context.Context, cfg *traffikey.Config, tgt *traffikey.Target) error {
+	if tgt == nil {
+		return status.Error(codes.InvalidArgument, "a target is required")
+	}
+
+	err := rejectGRPCInterpolation(tgt)
+	if err != nil {
+		return err
+	}
+
+	normalizeTarget(cfg, tgt)
+	if !grpcAllowed(ctx, traffikey.VERB_WRITE, tgt.Prefix, tgt.Type) {
+		return status.Errorf(codes.PermissionDenied, "token isn't allowed to write target %s/%s/%s", tgt.Prefix, tgt.Type, tgt.Name)
+	}
+
+	return nil
+}
+
+// rejectGRPCInterpolation refuses the ${...} expressions like the HTTP API,
+// they would be interpolated once the state is read again
+func rejectGRPCInterpolation(v interface{}) error {
+	found, err := hasInterpolation(v)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if found {
+		return status.Error(codes.InvalidArgument, INTERPOLATION_REJECTED)
+	}
+
+	return nil
+}
+
+func storeStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, keymate.ErrInvalidTarget) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, keymate.ErrLocked) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// readableState removes the targets the token of the call can't read
+func readableState(ctx context.Context, state *traffikey.Config) *api.Config {
+	if state == nil {
+		return nil
+	}
+
+	var targets []*traffikey.Target
+	for _, tgt := range state.Targets {
+		if grpcAllowed(ctx, traffikey.VERB_READ, tgt.Prefix, tgt.Type) {
+			targets = append(targets, tgt)
+		}
+	}
+
+	readable := *state
+	readable.Targets = targets
+	return api.FromConfig(&readable)
+}
+
+func (s *grpcServer) ApplyConfig(ctx context.Context, req *api.ApplyConfigRequest) (*api.ApplyConfigResponse, error) {
+	cfg := api.ToConfig(req.Config)
+	if cfg == nil {
+		return nil, status.Error(codes.InvalidArgument, "a configuration is required")
+	}
+
+	// The defaults of the server are used for the ones that aren't set
+	if cfg.Traefik.DefaultPrefix == "" {
+		cfg.Traefik.DefaultPrefix = s.cfg.Traefik.DefaultPrefix
+	}
+	if cfg.Traefik.DefaultEntrypoint == "" {
+		cfg.Traefik.DefaultEntrypoint = s.cfg.Traefik.DefaultEntrypoint
+	}
+
+	for _, tgt := range cfg.Targets {
+		err := s.checkTarget(ctx, cfg, tgt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	unlock, err := s.manager.Lock(ctx, cfg.Prefixes())
+	if err != nil {
+		return nil, storeStatus(err)
+	}
+	defer unlock()
+
+	resp := &api.ApplyConfigResponse{}
+	for _, err := range s.manager.ApplyConfig(ctx, cfg) {
+		resp.Errors = append(resp.Errors, err.Error())
+	}
+
+	return resp, nil
+}
+
+func (s *grpcServer) ApplyTarget(ctx context.Context, req *api.ApplyTargetRequest) (*api.ApplyTargetResponse, error) {
+	tgt := api.ToTarget(req.Target)
+	err := s.checkTarget(ctx, s.cfg, tgt)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.manager.Lock(ctx, []string{tgt.Prefix})
+	if err != nil {
+		return nil, storeStatus(err)
+	}
+	defer unlock()
+
+	return &api.ApplyTargetResponse{}, storeStatus(s.manager.ApplyTarget(ctx, tgt))
+}
+
+func (s *grpcServer) DeleteTarget(ctx context.Context, req *api.DeleteTargetRequest) (*api.DeleteTargetResponse, error) {
+	tgt := api.ToTarget(req.Target)
+	err := s.checkTarget(ctx, s.cfg, tgt)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.manager.Lock(ctx, []string{tgt.Prefix})
+	if err != nil {
+		return nil, storeStatus(err)
+	}
+	defer unlock()
+
+	return &api.DeleteTargetResponse{}, storeStatus(s.manager.DeleteTarget(ctx, tgt))
+}
+
+func (s *grpcServer) ListTargets(ctx context.Context, req *api.ListTargetsRequest) (*api.ListTargetsResponse, error) {
+	traefik := *s.cfg.Traefik
+	if req.Prefix != "" {
+		traefik.DefaultPrefix = req.Prefix
+	}
+
+	if !grpcAllowed(ctx, traffikey.VERB_READ, traefik.DefaultPrefix, "") {
+		return nil, status.Errorf(codes.PermissionDenied, "token isn't allowed to read prefix %s", traefik.DefaultPrefix)
+	}
+
+	targets, err := s.manager.ListTargets(ctx, &traffikey.Config{Traefik: &traefik})
+	if err != nil {
+		return nil, storeStatus(err)
+	}
+
+	resp := &api.ListTargetsResponse{}
+	for _, tgt := range targets {
+		if grpcAllowed(ctx, traffikey.VERB_READ, tgt.Prefix, tgt.Type) {
+			resp.Targets = append(resp.Targets, api.FromTarget(tgt))
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *grpcServer) GetState(ctx context.Context, req *api.GetStateRequest) (*api.GetStateResponse, error) {
+	state, err := s.manager.GetState(ctx)
+	if err != nil {
+		return nil, storeStatus(err)
+	}
+
+	return &api.GetStateResponse{State: readableState(ctx, state)}, nil
+}
+
+func (s *grpcServer) SaveState(ctx context.Context, req *api.SaveStateRequest) (*api.SaveStateResponse, error) {
+	// The state holds the targets of every prefix and type
+	auth, _ := ctx.Value(grpcAuthorizationKey{}).(*authorization)
+	if auth != nil && auth.Token.Scoped() {
+		return nil, status.Errorf(codes.PermissionDenied, "token %s is limited to some prefixes or types and can't replace the state", auth.Name)
+	}
+
+	state := api.ToConfig(req.State)
+	if state == nil {
+		return nil, status.Error(codes.InvalidArgument, "a state is required")
+	}
+
+	err := rejectGRPCInterpolation(state)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.manager.Lock(ctx, state.Prefixes())
+	if err != nil {
+		return nil, storeStatus(err)
+	}
+	defer unlock()
+
+	return &api.SaveStateResponse{}, storeStatus(s.manager.SaveState(ctx, state))
+}
+
+func (s *grpcServer) WatchState(req *api.WatchStateRequest, stream api.Traffikey_WatchStateServer) error {
+	ctx := stream.Context()
+
+	states, err := s.manager.WatchState(ctx)
+	if err != nil {
+		return storeStatus(err)
+	}
+
+	for state := range states {
+		err := stream.Send(&api.WatchStateResponse{State: readableState(ctx, state)})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
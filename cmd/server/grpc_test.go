@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/client"
+)
+
+func TestGRPCClient(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Traefik.DefaultPrefix = "traefik"
+
+	tokens := map[string]*traffikey.TokenConfig{
+		"admin": {Hash: traffikey.HashToken("secret"), Verbs: []string{traffikey.VERB_READ, traffikey.VERB_WRITE}},
+		"other": {Hash: traffikey.HashToken("other"), Prefixes: []string{"other"}, Verbs: []string{traffikey.VERB_READ, traffikey.VERB_WRITE}},
+	}
+	auth := newAuthenticator(func() map[string]*traffikey.TokenConfig { return tokens })
+
+	mgr := &stateManager{applied: make(map[string]*traffikey.Target)}
+	server := newGRPCServer(&grpcServer{manager: mgr, cfg: cfg, auth: auth})
+	defer server.Stop()
+
+	lis := bufconn.Listen(1024 * 1024)
+	go server.Serve(lis)
+
+	dial := func(token string) *client.Client {
+		c, err := client.NewInsecure("bufnet", token,
+			grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	ctx := context.Background()
+
+	_, err := dial("wrong").GetState(ctx)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	admin := dial("secret")
+	tgt := &traffikey.Target{
+		Name:        "web",
+		ServerURLs:  []string{"http://10.0.0.1"},
+		Middlewares: []*traffikey.Middleware{{Name: "auth", Kind: "basicAuth", Values: map[string]string{"users": "a:b"}}},
+		HealthCheck: &traffikey.HealthCheck{Interval: traffikey.Duration(30e9)},
+	}
+	require.NoError(t, admin.ApplyTarget(ctx, tgt))
+	require.Contains(t, mgr.applied, "web")
+	assert.Equal(t, "traefik", mgr.applied["web"].Prefix, "defaults of the server are used")
+	assert.Equal(t, tgt.Middlewares, mgr.applied["web"].Middlewares)
+	assert.Equal(t, tgt.HealthCheck, mgr.applied["web"].HealthCheck)
+
+	multi := &traffikey.Target{Name: "multi", Prefixes: []string{"edge", "internal"}, ServerURLs: []string{"http://10.0.0.1"}}
+	assert.ErrorContains(t, admin.ApplyTarget(ctx, multi), "single prefix", "the prefixes would be dropped")
+	assert.Len(t, admin.ApplyConfig(ctx, &traffikey.Config{Targets: []*traffikey.Target{multi}}), 1)
+	assert.NotContains(t, mgr.applied, "multi")
+
+	err = admin.ApplyTarget(ctx, &traffikey.Target{Name: "empty"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "invalid targets are rejected")
+
+	secret := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("password"), 0600))
+	leaking := &traffikey.Target{
+		Name:        "leak",
+		ServerURLs:  []string{"http://10.0.0.1"},
+		Middlewares: []*traffikey.Middleware{{Name: "leak", Kind: "headers", Values: map[string]string{"customrequestheaders/X-Leak": "${file:" + secret + "}"}}},
+	}
+	err = admin.ApplyTarget(ctx, leaking)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "only configuration files are interpolated")
+	errs := admin.ApplyConfig(ctx, &traffikey.Config{Targets: []*traffikey.Target{leaking}})
+	require.Len(t, errs, 1)
+	assert.Equal(t, codes.InvalidArgument, status.Code(errs[0]))
+	err = admin.SaveState(ctx, &traffikey.Config{Targets: []*traffikey.Target{leaking}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.NotContains(t, mgr.applied, "leak")
+
+	state, err := admin.GetState(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, state, "nothing was saved yet")
+
+	require.NoError(t, admin.SaveState(ctx, &traffikey.Config{Targets: []*traffikey.Target{mgr.applied["web"]}}))
+
+	other := dial("other")
+	err = other.ApplyTarget(ctx, &traffikey.Target{Name: "web", ServerURLs: []string{"http://10.0.0.1"}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "token of another prefix")
+	err = other.SaveState(ctx, &traffikey.Config{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "scoped tokens can't replace the state")
+
+	state, err = other.GetState(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, state.Targets, "targets of other prefixes are hidden")
+
+	state, err = admin.GetState(ctx)
+	require.NoError(t, err)
+	require.Len(t, state.Targets, 1)
+	assert.Equal(t, "web", state.Targets[0].Name)
+
+	// Nothing can be read without tokens
+	tokens = nil
+	_, err = dial("").GetState(ctx)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// writeCertificate writes a self signed certificate for the host and its key in PEM
+func writeCertificate(t *testing.T, host string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func TestGRPCTLS(t *testing.T) {
+	cfg := testConfig(t)
+	tokens := map[string]*traffikey.TokenConfig{
+		"admin": {Hash: traffikey.HashToken("secret"), Verbs: []string{traffikey.VERB_READ}},
+	}
+	auth := newAuthenticator(func() map[string]*traffikey.TokenConfig { return tokens })
+
+	_, err := grpcServerOptions("cert.pem", "")
+	assert.Error(t, err, "the key is required with the certificate")
+
+	certFile, keyFile := writeCertificate(t, "bufnet")
+	opts, err := grpcServerOptions(certFile, keyFile)
+	require.NoError(t, err)
+
+	server := newGRPCServer(&grpcServer{manager: &stateManager{}, cfg: cfg, auth: auth}, opts...)
+	defer server.Stop()
+
+	lis := bufconn.Listen(1024 * 1024)
+	go server.Serve(lis)
+	dialer := grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) { return lis.DialContext(ctx) })
+	ctx := context.Background()
+
+	pool := x509.NewCertPool()
+	pemCert, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	require.True(t, pool.AppendCertsFromPEM(pemCert))
+
+	c, err := client.New("bufnet", "secret", dialer, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, "")))
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = c.GetState(ctx)
+	assert.NoError(t, err)
+
+	// The token isn't sent without TLS unless asked for
+	_, err = client.New("bufnet", "secret", dialer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.ErrorContains(t, err, "transport level security")
+}
@@ -2,12 +2,45 @@ package main
 
 import (
 	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+
+	traffikey "github.com/numkem/traffikey"
 )
 
 type handler struct {
 	monitor *Monitor
 }
 
-func (h *handler) List(c echo.Context) error {
-	return c.JSON(200, nil)
+type statusResponse struct {
+	Identity string `json:"identity"`
+	Leader   bool   `json:"leader"`
+	// Identity of the instance currently allowed to mutate the store
+	CurrentLeader string         `json:"current_leader"`
+	Targets       []*TargetState `json:"targets"`
+}
+
+// states returns the states of the targets the token of the request can read
+func (h *handler) states(c echo.Context) []*TargetState {
+	var states []*TargetState
+	for _, s := range h.monitor.States() {
+		if allowedKey(c, traffikey.VERB_READ, s.Key) {
+			states = append(states, s)
+		}
+	}
+
+	return states
+}
+
+func (h *handler) Status(c echo.Context) error {
+	currentLeader, err := h.monitor.manager.Leader(c.Request().Context(), MONITOR_ELECTION)
+	if err != nil {
+		log.Warnf("failed to get the current monitor leader: %v", err)
+	}
+
+	return c.JSON(200, &statusResponse{
+		Identity:      h.monitor.Identity,
+		Leader:        h.monitor.IsLeader(),
+		CurrentLeader: currentLeader,
+		Targets:       h.states(c),
+	})
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	log "github.com/sirupsen/logrus"
+
+	traffikey "github.com/numkem/traffikey"
+)
+
+// Delay between two writes of the probe history to the store
+const HISTORY_FLUSH_INTERVAL = time.Minute
+
+// Windows over which the uptime is reported
+var uptimeWindows = []struct {
+	Name   string
+	Window time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// loadHistory replaces the history of the target by the one persisted in the
+// store. When the target starts, the probes done while the history was loading
+// are kept and it is only loaded once. When taking over the leadership, the
+// local history is dropped for the one the previous leader saved.
+func (m *Monitor) loadHistory(status *targetStatus, takeover bool) {
+	status.mu.RLock()
+	key := status.History.Key
+	status.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+	defer cancel()
+
+	history, err := m.manager.GetHistory(ctx, key)
+	if err != nil {
+		log.WithField("target", key).Warnf("failed to load history: %v", err)
+		return
+	}
+
+	status.mu.Lock()
+	defer status.mu.Unlock()
+
+	// The local history already holds the stored one
+	if status.historyLoaded && !takeover {
+		return
+	}
+	status.historyLoaded = true
+
+	if history == nil {
+		return
+	}
+
+	if !takeover {
+		// Keep the probes done while the history was loading
+		for _, b := range status.History.Buckets {
+			for i := 0; i < b.Probes; i++ {
+				history.RecordProbe(b.Hour, b.Server, i < b.Successes)
+			}
+		}
+		history.Transitions = append(history.Transitions, status.History.Transitions...)
+	}
+	history.Name = status.History.Name
+
+	status.History = history
+}
+
+// saveHistories writes the histories that changed to the store. Only the leader writes them.
+func (m *Monitor) saveHistories(ctx context.Context) {
+	if !m.IsLeader() {
+		return
+	}
+
+	m.currentTargets.Range(func(key, value interface{}) bool {
+		status := value.(*monitoredTarget).Status
+
+		status.mu.Lock()
+		if !status.historyDirty {
+			status.mu.Unlock()
+			return true
+		}
+
+		status.History.Compact(time.Now(), traffikey.HISTORY_RETENTION)
+		status.historyDirty = false
+		history := status.History.Copy()
+		status.mu.Unlock()
+
+		err := m.manager.SaveHistory(ctx, history)
+		if err != nil {
+			log.WithField("target", key).Warnf("failed to save history: %v", err)
+		}
+
+		return true
+	})
+}
+
+func (m *Monitor) flushHistories() {
+	ticker := time.NewTicker(HISTORY_FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+
+		case <-ticker.C:
+			m.saveHistories(m.ctx)
+		}
+	}
+}
+
+// Histories returns a copy of the history of every monitored target sorted by key
+func (m *Monitor) Histories() []*traffikey.TargetHistory {
+	var histories []*traffikey.TargetHistory
+	m.currentTargets.Range(func(key, value interface{}) bool {
+		status := value.(*monitoredTarget).Status
+
+		status.mu.RLock()
+		histories = append(histories, status.History.Copy())
+		status.mu.RUnlock()
+		return true
+	})
+
+	sort.Slice(histories, func(i, j int) bool { return histories[i].Key < histories[j].Key })
+
+	return histories
+}
+
+type uptimeReport struct {
+	Server string `json:"server,omitempty"`
+	// Percentage of successful probes by window, missing when there were no probes
+	Uptime map[string]float64 `json:"uptime"`
+}
+
+type historyReport struct {
+	Key       string                `json:"key"`
+	Name      string                `json:"name"`
+	Uptime    map[string]float64    `json:"uptime"`
+	Servers   []*uptimeReport       `json:"servers"`
+	Incidents []*traffikey.Incident `json:"incidents,omitempty"`
+}
+
+func uptimeByWindow(history *traffikey.TargetHistory, server string, now time.Time) map[string]float64 {
+	uptime := make(map[string]float64)
+	for _, w := range uptimeWindows {
+		if v, ok := history.Uptime(server, w.Window, now); ok {
+			uptime[w.Name] = v
+		}
+	}
+
+	return uptime
+}
+
+func newHistoryReport(history *traffikey.TargetHistory, now time.Time) *historyReport {
+	report := &historyReport{
+		Key:     history.Key,
+		Name:    history.Name,
+		Uptime:  uptimeByWindow(history, "", now),
+		Servers: []*uptimeReport{},
+	}
+
+	for _, server := range history.Servers() {
+		report.Servers = append(report.Servers, &uptimeReport{
+			Server: server,
+			Uptime: uptimeByWindow(history, server, now),
+		})
+	}
+
+	return report
+}
+
+// History returns the uptime of every monitored target
+func (h *handler) History(c echo.Context) error {
+	now := time.Now()
+
+	reports := []*historyReport{}
+	for _, history := range h.monitor.Histories() {
+		if allowedKey(c, traffikey.VERB_READ, history.Key) {
+			reports = append(reports, newHistoryReport(history, now))
+		}
+	}
+
+	return c.JSON(http.StatusOK, reports)
+}
+
+// TargetHistory returns the uptime and the incidents of a single target
+func (h *handler) TargetHistory(c echo.Context) error {
+	key := c.Param("*")
+	if !allowedKey(c, traffikey.VERB_READ, key) {
+		return echo.NewHTTPError(http.StatusForbidden, "token isn't allowed to read this target")
+	}
+
+	for _, history := range h.monitor.Histories() {
+		if history.Key == key {
+			report := newHistoryReport(history, time.Now())
+			report.Incidents = history.Incidents()
+
+			return c.JSON(http.StatusOK, report)
+		}
+	}
+
+	return echo.NewHTTPError(http.StatusNotFound, "target isn't monitored")
+}
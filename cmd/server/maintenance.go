@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errNotLeader = errors.New("this instance isn't the monitor leader")
+
+// refreshMaintenance reads the targets in maintenance from the store
+func (m *Monitor) refreshMaintenance(ctx context.Context) {
+	maintenance, err := m.manager.ListMaintenance(ctx)
+	if err != nil {
+		log.Warnf("failed to get the targets in maintenance: %v", err)
+		return
+	}
+
+	m.maintenanceMu.Lock()
+	m.maintenance = maintenance
+	m.maintenanceMu.Unlock()
+}
+
+// InMaintenance tells if the target with the given key is in maintenance.
+// Notifications about targets in maintenance aren't sent.
+func (m *Monitor) InMaintenance(key string) bool {
+	m.maintenanceMu.RLock()
+	defer m.maintenanceMu.RUnlock()
+
+	_, ok := m.maintenance[key]
+	return ok
+}
+
+// SetMaintenance puts a target in or out of maintenance. Only the leader can change it.
+func (m *Monitor) SetMaintenance(ctx context.Context, key string, enabled bool) error {
+	if !m.IsLeader() {
+		return errNotLeader
+	}
+
+	prefix, _, ok := splitTargetKey(key)
+	if !ok {
+		return fmt.Errorf("invalid target key %q", key)
+	}
+	unlock, err := m.manager.Lock(ctx, []string{prefix})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = m.manager.SetMaintenance(ctx, key, enabled)
+	if err != nil {
+		return err
+	}
+
+	m.maintenanceMu.Lock()
+	if enabled {
+		m.maintenance[key] = time.Now()
+	} else {
+		delete(m.maintenance, key)
+	}
+	m.maintenanceMu.Unlock()
+
+	m.events.Publish(&MonitorEvent{Type: EVENT_MAINTENANCE, Key: key, Up: !enabled})
+
+	return nil
+}
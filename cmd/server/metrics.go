@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var metricLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter renders metrics in the Prometheus text format
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (w *metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], metricLabelReplacer.Replace(labels[i+1])))
+	}
+
+	if len(pairs) > 0 {
+		fmt.Fprintf(&w.b, "%s{%s} %g\n", name, strings.Join(pairs, ","), value)
+	} else {
+		fmt.Fprintf(&w.b, "%s %g\n", name, value)
+	}
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (h *handler) Metrics(c echo.Context) error {
+	w := &metricsWriter{}
+	states := h.states(c)
+
+	w.header("traffikey_monitor_leader", "gauge", "Whether this instance is the monitor leader")
+	w.sample("traffikey_monitor_leader", boolMetric(h.monitor.IsLeader()))
+
+	w.header("traffikey_target_up", "gauge", "Whether at least one server of the target is alive")
+	for _, s := range states {
+		w.sample("traffikey_target_up", boolMetric(s.Up), "key", s.Key, "target", s.Name)
+	}
+
+	w.header("traffikey_target_alive_servers", "gauge", "Number of servers of the target that are alive")
+	for _, s := range states {
+		w.sample("traffikey_target_alive_servers", float64(len(s.AliveURLs)), "key", s.Key, "target", s.Name)
+	}
+
+	w.header("traffikey_certificate_expiry_timestamp_seconds", "gauge", "Expiry date of the certificate served for the target")
+	for _, s := range states {
+		for _, cert := range s.Certificates {
+			if cert.Error != "" {
+				continue
+			}
+
+			w.sample("traffikey_certificate_expiry_timestamp_seconds", float64(cert.NotAfter.Unix()),
+				"key", s.Key, "target", s.Name, "endpoint", cert.Endpoint, "address", cert.Address, "issuer", cert.Issuer)
+		}
+	}
+
+	w.header("traffikey_certificate_valid", "gauge", "Whether the certificate could be fetched and covers the server name")
+	for _, s := range states {
+		for _, cert := range s.Certificates {
+			w.sample("traffikey_certificate_valid", boolMetric(cert.Error == "" && cert.HostnameValid),
+				"key", s.Key, "target", s.Name, "endpoint", cert.Endpoint, "address", cert.Address)
+		}
+	}
+
+	return c.String(200, w.b.String())
+}
@@ -3,21 +3,54 @@ package main
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofrs/uuid"
 	traffikey "github.com/numkem/traffikey"
 	"github.com/numkem/traffikey/keymate"
+	"github.com/numkem/traffikey/notify"
 
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// Name of the election between monitor instances
+	MONITOR_ELECTION = "monitor"
+	// Default TTL in seconds of the leader's lease
+	DEFAULT_ELECTION_TTL = 10
+)
+
 type Monitor struct {
+	mu             sync.Mutex
 	currentTargets *sync.Map
 	manager        keymate.KeymateConnector
 	cfg            *traffikey.Config
+	configFilename string
+	scheduler      *scheduler
+	events         *eventBus
+
+	notifierMu sync.RWMutex
+	notifier   *notify.Notifier
+
+	// Keys of the targets in maintenance
+	maintenanceMu sync.RWMutex
+	maintenance   map[string]time.Time
+
+	// Identity of this instance in the leader election
+	Identity    string
+	ElectionTTL int
+	leader      atomic.Bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewMonitor(configFilename string) (*Monitor, error) {
@@ -42,14 +75,34 @@ func NewMonitor(configFilename string) (*Monitor, error) {
 		log.Fatalf("failed to create manager: %v", err)
 	}
 
-	return &Monitor{cfg: cfg, currentTargets: &sync.Map{}, manager: mgr}, nil
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %v", err)
+	}
+
+	notifier, err := notify.NewNotifier(cfg.Notifiers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notifier: %v", err)
+	}
+
+	return &Monitor{
+		notifier:       notifier,
+		cfg:            cfg,
+		configFilename: configFilename,
+		currentTargets: &sync.Map{},
+		events:         newEventBus(),
+		maintenance:    make(map[string]time.Time),
+		manager:        mgr,
+		Identity:       hostname,
+		ElectionTTL:    DEFAULT_ELECTION_TTL,
+	}, nil
 }
 
-func testTarget(tgt *traffikey.Target) []string {
+func testTarget(tgt *traffikey.Target, timeout time.Duration) []string {
 	// Attempt a test connection to the target
 	switch tgt.Type {
 	case "http":
-		return testHTTPTarget(tgt)
+		return testHTTPTarget(tgt, timeout)
 	default:
 		log.WithField("target", tgt.Name).Warnf("invalid target type %s", tgt.Type)
 	}
@@ -57,17 +110,32 @@ func testTarget(tgt *traffikey.Target) []string {
 	return []string{}
 }
 
-func testHTTPTarget(tgt *traffikey.Target) []string {
-	// Try to connect to the http endpoint with a very small timeout
-	aliveUrls := []string{}
-	for _, url := range tgt.ServerURLs {
-		client := http.Client{
-			Timeout: 1 * time.Second,
-		}
+func testHTTPTarget(tgt *traffikey.Target, timeout time.Duration) []string {
+	client := http.Client{
+		Timeout: timeout,
+	}
+
+	// Probe all the servers at the same time
+	alive := make([]bool, len(tgt.ServerURLs))
+	var wg sync.WaitGroup
+	for i, url := range tgt.ServerURLs {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+
+			resp, err := client.Get(url)
+			// TODO: Check the HTTP response code
+			if err == nil {
+				resp.Body.Close()
+				alive[i] = true
+			}
+		}(i, url)
+	}
+	wg.Wait()
 
-		_, err := client.Get(url)
-		// TODO: Check the HTTP response code
-		if err == nil {
+	aliveUrls := []string{}
+	for i, url := range tgt.ServerURLs {
+		if alive[i] {
 			aliveUrls = append(aliveUrls, url)
 		}
 	}
@@ -75,68 +143,569 @@ func testHTTPTarget(tgt *traffikey.Target) []string {
 	return aliveUrls
 }
 
+// targetStatus is the result of the probes done on a target. It is kept
+// between restarts of the target's monitoring when its configuration changes.
+type targetStatus struct {
+	mu        sync.RWMutex
+	Up        bool
+	AliveURLs []string
+	LastCheck time.Time
+
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+
+	// Last known state of each server
+	servers map[string]bool
+
+	History      *traffikey.TargetHistory
+	historyDirty bool
+	// Whether the history persisted in the store was loaded
+	historyLoaded bool
+
+	Certificates          []*CertificateState
+	LastCertificatesCheck time.Time
+	// A check of the certificates is running
+	checkingCertificates bool
+}
+
+// Targets are considered up until enough probes fail
+func newTargetStatus(key string, name string) *targetStatus {
+	return &targetStatus{
+		Up:      true,
+		servers: make(map[string]bool),
+		History: &traffikey.TargetHistory{Key: key, Name: name},
+	}
+}
+
+// update records the result of a probe and returns the transitions it caused.
+// The target changes state once the thresholds of the health check are reached.
+func (s *targetStatus) update(servers []string, aliveUrls []string, hc *traffikey.HealthCheck) []*traffikey.Transition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.AliveURLs = aliveUrls
+	s.LastCheck = now
+	s.historyDirty = true
+
+	var transitions []*traffikey.Transition
+	for _, server := range servers {
+		alive := slices.Contains(aliveUrls, server)
+		s.History.RecordProbe(now, server, alive)
+
+		if previous, ok := s.servers[server]; ok && previous != alive {
+			s.History.RecordTransition(now, server, alive)
+			transitions = append(transitions, &traffikey.Transition{Time: now, Server: server, Up: alive})
+		}
+		s.servers[server] = alive
+	}
+	s.History.RecordProbe(now, "", len(aliveUrls) > 0)
+
+	if len(aliveUrls) > 0 {
+		s.ConsecutiveSuccesses++
+		s.ConsecutiveFailures = 0
+	} else {
+		s.ConsecutiveFailures++
+		s.ConsecutiveSuccesses = 0
+	}
+
+	switch {
+	case s.Up && s.ConsecutiveFailures >= hc.FailureThreshold:
+		s.Up = false
+	case !s.Up && s.ConsecutiveSuccesses >= hc.SuccessThreshold:
+		s.Up = true
+	default:
+		return transitions
+	}
+
+	s.History.RecordTransition(now, "", s.Up)
+	return append(transitions, &traffikey.Transition{Time: now, Up: s.Up})
+}
+
+// targetChanged tells if the target itself is part of the transitions
+func targetChanged(transitions []*traffikey.Transition) bool {
+	for _, t := range transitions {
+		if t.Server == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nextProbe returns the delay before the next probe. Down targets are
+// probed less and less often, up to the maximum backoff.
+func (s *targetStatus) nextProbe(hc *traffikey.HealthCheck) time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	delay := time.Duration(hc.Interval)
+	if !s.Up {
+		for i := hc.FailureThreshold; i < s.ConsecutiveFailures && delay < time.Duration(hc.MaxBackoff); i++ {
+			delay *= 2
+		}
+
+		if delay > time.Duration(hc.MaxBackoff) {
+			delay = time.Duration(hc.MaxBackoff)
+		}
+	}
+
+	if hc.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(hc.Jitter)))
+	}
+
+	return delay
+}
+
+// TargetState is a snapshot of the status of a monitored target
+type TargetState struct {
+	Key       string    `json:"key"`
+	Name      string    `json:"name"`
+	Up        bool      `json:"up"`
+	AliveURLs []string  `json:"alive_urls"`
+	LastCheck time.Time `json:"last_check"`
+
+	Certificates []*CertificateState `json:"certificates"`
+}
+
+func (s *targetStatus) snapshot(key string, tgt *traffikey.Target) *TargetState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return &TargetState{
+		Key:       key,
+		Name:      tgt.Name,
+		Up:        s.Up,
+		AliveURLs: s.AliveURLs,
+		LastCheck: s.LastCheck,
+
+		Certificates: s.Certificates,
+	}
+}
+
+// startCertificatesCheck tells if the certificates are due for a check and
+// marks it as running, so that a single check runs at a time
+func (s *targetStatus) startCertificatesCheck() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.checkingCertificates || time.Since(s.LastCertificatesCheck) < CERTIFICATE_CHECK_INTERVAL {
+		return false
+	}
+
+	s.checkingCertificates = true
+	return true
+}
+
+// cancelCertificatesCheck ends a check without recording its result, the
+// next probe starts another one
+func (s *targetStatus) cancelCertificatesCheck() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkingCertificates = false
+}
+
+func (s *targetStatus) updateCertificates(certs []*CertificateState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Certificates = certs
+	s.LastCertificatesCheck = time.Now()
+	s.checkingCertificates = false
+}
+
 type monitoredTarget struct {
-	ID      string
-	Context context.Context
-	Cancel  context.CancelFunc
-	Target  *traffikey.Target
+	ID          string
+	Key         string
+	Context     context.Context
+	Cancel      context.CancelFunc
+	Target      *traffikey.Target
+	HealthCheck *traffikey.HealthCheck
+	Status      *targetStatus
+}
+
+// targetKey returns a key unique to a target across prefixes and router types
+func (m *Monitor) targetKey(tgt *traffikey.Target) string {
+	prefix := tgt.Prefix
+	if prefix == "" {
+		prefix = m.cfg.Traefik.DefaultPrefix
+	}
+
+	routerType := tgt.Type
+	if routerType == "" {
+		routerType = "http"
+	}
+
+	return fmt.Sprintf("%s/%s/%s", prefix, routerType, tgt.Name)
 }
 
 func (m *Monitor) Start() {
-	// Start a monitor goroutine for each target
-	for _, tgt := range m.cfg.Targets {
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	m.scheduler = newScheduler(m.cfg.Monitor.Workers, m.probe)
+	go m.scheduler.run(m.ctx)
+
+	err := m.reload(m.cfg)
+	if err != nil {
+		log.Errorf("failed to load monitored targets: %v", err)
+	}
+
+	go m.watchConfig()
+	go m.watchState()
+	go m.runElection()
+	go m.flushHistories()
+}
+
+func (m *Monitor) Stop() {
+	m.cancel()
+
+	m.currentTargets.Range(func(key, value interface{}) bool {
+		mt := value.(*monitoredTarget)
+
+		log.Debugf("Stopping monitoring of target ID %s :: %s", mt.ID, mt.Target.Name)
+		mt.Cancel()
+		return true
+	})
+
+	m.notifierMu.RLock()
+	m.notifier.Close()
+	m.notifierMu.RUnlock()
+
+	m.saveHistories(context.Background())
+}
+
+// setNotifier replaces the notifier, which continues where the previous one
+// left, and sends the events still pending in the previous one
+func (m *Monitor) setNotifier(cfgs map[string]*traffikey.NotifierConfig) error {
+	notifier, err := notify.NewNotifier(cfgs)
+	if err != nil {
+		return err
+	}
+
+	m.notifierMu.Lock()
+	previous := m.notifier
+	notifier.TakeOver(previous)
+	m.notifier = notifier
+	m.notifierMu.Unlock()
+
+	previous.Close()
+	return nil
+}
+
+// Tokens returns the tokens allowed to use the API of the monitor
+func (m *Monitor) Tokens() map[string]*traffikey.TokenConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cfg.Tokens
+}
+
+func (m *Monitor) setTokens(tokens map[string]*traffikey.TokenConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cfg.Tokens = tokens
+}
+
+// notify alerts the notifiers of the target about its change of state.
+// Only the leader sends notifications so that they aren't duplicated.
+func (m *Monitor) notify(mt *monitoredTarget, aliveUrls []string) {
+	if len(mt.Target.Notify) == 0 || !m.IsLeader() || m.InMaintenance(mt.Key) {
+		return
+	}
+
+	m.notifierMu.RLock()
+	defer m.notifierMu.RUnlock()
+
+	m.notifier.Notify(mt.Target.Notify, &notify.Event{
+		Target:     mt.Target.Name,
+		Prefix:     mt.Target.Prefix,
+		Up:         len(aliveUrls) > 0,
+		AliveURLs:  aliveUrls,
+		ServerURLs: mt.Target.ServerURLs,
+	})
+}
+
+// comparableTarget returns a copy of the target normalized like the ones of
+// the state, with its health check merged and without its source, so that
+// the targets of the configuration file and of the state compare equal
+func (m *Monitor) comparableTarget(tgt *traffikey.Target) *traffikey.Target {
+	t := *tgt
+	normalizeTarget(m.cfg, &t)
+	t.HealthCheck = t.HealthCheck.Merge(m.cfg.Monitor.HealthCheck)
+	t.Source = ""
+
+	return &t
+}
+
+// reload starts, restarts and stops monitoring the targets of the
+// configuration, the previous targets are kept when it is invalid
+func (m *Monitor) reload(cfg *traffikey.Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := cfg.ValidateHealthChecks(m.cfg.Monitor.HealthCheck)
+	if err != nil {
+		return err
+	}
+
+	m.cfg.Targets = cfg.Targets
+
+	wanted := make(map[string]*traffikey.Target)
+	for _, tgt := range cfg.Targets {
 		if !tgt.Monitored {
 			log.WithField("target", tgt.Name).Infof("target %s isn't monitored", tgt.Name)
 			continue
 		}
 
-		id, err := uuid.NewV4()
+		wanted[m.targetKey(tgt)] = tgt
+	}
+
+	m.currentTargets.Range(func(key, value interface{}) bool {
+		mt := value.(*monitoredTarget)
+
+		tgt, ok := wanted[key.(string)]
+		switch {
+		case !ok:
+			log.WithField("target", mt.Target.Name).Info("target removed, stopping monitoring")
+			mt.Cancel()
+			m.currentTargets.Delete(key)
+
+		case !reflect.DeepEqual(m.comparableTarget(tgt), m.comparableTarget(mt.Target)):
+			log.WithField("target", mt.Target.Name).Info("target changed, restarting monitoring")
+			mt.Cancel()
+			m.startTarget(key.(string), tgt, mt.Status)
+		}
+
+		delete(wanted, key.(string))
+		return true
+	})
+
+	for key, tgt := range wanted {
+		status := newTargetStatus(key, tgt.Name)
+		m.startTarget(key, tgt, status)
+		go m.loadHistory(status, false)
+	}
+
+	return nil
+}
+
+func (m *Monitor) startTarget(key string, tgt *traffikey.Target, status *targetStatus) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.WithField("target", tgt.Name).Errorf("failed to generate UUID: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	mt := &monitoredTarget{
+		ID:          id.String(),
+		Key:         key,
+		Context:     ctx,
+		Cancel:      cancel,
+		Target:      tgt,
+		HealthCheck: tgt.HealthCheck.Merge(m.cfg.Monitor.HealthCheck),
+		Status:      status,
+	}
+	m.currentTargets.Store(key, mt)
+
+	// Spread the first probes of the targets over their interval
+	start := time.Now()
+	if mt.HealthCheck.Interval > 0 {
+		start = start.Add(time.Duration(rand.Int63n(int64(mt.HealthCheck.Interval))))
+	}
+
+	log.WithField("target", tgt.Name).Debug("starting monitoring of target")
+	m.scheduler.schedule(mt, start)
+}
+
+// watchConfig reloads the targets when the configuration file changes
+func (m *Monitor) watchConfig() {
+	changes, err := watchFile(m.ctx, configPaths(m.configFilename, m.cfg)...)
+	if err != nil {
+		log.Errorf("failed to watch configuration file: %v", err)
+		return
+	}
+
+	for range changes {
+		cfg, err := traffikey.NewConfig(m.configFilename)
+		if err != nil {
+			log.Errorf("failed to reload configuration: %v", err)
+			continue
+		}
+
+		log.WithField("filename", m.configFilename).Info("configuration file changed, reloading targets")
+		err = m.reload(cfg)
 		if err != nil {
-			log.WithField("target", tgt.Name).Errorf("failed to generate UUID: %v", err)
+			log.Errorf("failed to reload targets, keeping the previous ones: %v", err)
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		mt := &monitoredTarget{
-			ID:      id.String(),
-			Context: ctx,
-			Cancel:  cancel,
-			Target:  tgt,
+		err = m.setNotifier(cfg.Notifiers)
+		if err != nil {
+			log.Errorf("failed to reload notifiers: %v", err)
 		}
-		m.currentTargets.Store(id, mt)
 
-		log.WithField("target", tgt.Name).Debug("starting monitoring of target")
-		go watchTarget(mt)
+		m.setTokens(cfg.Tokens)
 	}
 }
 
-func (m *Monitor) Stop() {
+// watchState reloads the targets when a new state is saved in the store
+func (m *Monitor) watchState() {
+	states, err := m.manager.WatchState(m.ctx)
+	if err != nil {
+		log.Errorf("failed to watch state: %v", err)
+		return
+	}
+
+	for cfg := range states {
+		log.Info("state changed in the store, reloading targets")
+		err := cfg.Interpolate()
+		if err != nil {
+			log.Errorf("failed to interpolate state, keeping the previous targets: %v", err)
+			continue
+		}
+
+		m.events.Publish(&MonitorEvent{
+			Type:    EVENT_APPLY,
+			Message: fmt.Sprintf("configuration applied with %d targets", len(cfg.Targets)),
+		})
+
+		err = m.reload(cfg)
+		if err != nil {
+			log.Errorf("failed to reload targets from state, keeping the previous ones: %v", err)
+		}
+	}
+}
+
+// runElection takes part in the election between the monitor instances. Only
+// the leader is allowed to mutate the store, followers keep probing targets.
+func (m *Monitor) runElection() {
+	leadership, err := m.manager.Campaign(m.ctx, MONITOR_ELECTION, m.Identity, m.ElectionTTL)
+	if err != nil {
+		log.Errorf("failed to take part in the monitor election: %v", err)
+		return
+	}
+
+	for leader := range leadership {
+		m.leader.Store(leader)
+
+		if leader {
+			log.WithField("identity", m.Identity).Info("elected as the monitor leader")
+
+			// Continue where the previous leader left
+			m.refreshMaintenance(m.ctx)
+			m.currentTargets.Range(func(key, value interface{}) bool {
+				m.loadHistory(value.(*monitoredTarget).Status, true)
+				return true
+			})
+		} else {
+			log.WithField("identity", m.Identity).Warn("lost the monitor leadership, now a follower")
+		}
+	}
+}
+
+// States returns the status of every monitored target sorted by key
+func (m *Monitor) States() []*TargetState {
+	states := []*TargetState{}
 	m.currentTargets.Range(func(key, value interface{}) bool {
 		mt := value.(*monitoredTarget)
-
-		log.Debugf("Stopping monitoring of target ID %s :: %s", mt.ID, mt.Target.Name)
-		mt.Cancel()
+		states = append(states, mt.Status.snapshot(key.(string), mt.Target))
 		return true
 	})
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+
+	return states
+}
+
+// checkCertificates records the certificates served for the target and
+// alerts its notifiers about the ones expiring soon. It runs on its own
+// goroutine so that the handshakes don't hold the probe workers.
+func (m *Monitor) checkCertificates(mt *monitoredTarget) {
+	endpoints := certificateEndpoints(mt.Target)
+	if len(endpoints) == 0 {
+		mt.Status.updateCertificates(nil)
+		return
+	}
+
+	warningDays := m.cfg.Monitor.CertificateWarningDays
+	if warningDays == 0 {
+		warningDays = DEFAULT_CERTIFICATE_WARNING_DAYS
+	}
+
+	var certs []*CertificateState
+	for _, ep := range endpoints {
+		cert := checkCertificate(mt.Context, ep)
+		if mt.Context.Err() != nil {
+			// The target was stopped during the check
+			mt.Status.cancelCertificatesCheck()
+			return
+		}
+		certs = append(certs, cert)
+
+		problem := certificateProblem(cert, warningDays, time.Now())
+		message := fmt.Sprintf("certificate of %s for target %s is valid until %s", cert.Address, mt.Target.Name, cert.NotAfter.Format(time.DateOnly))
+		if problem != "" {
+			message = fmt.Sprintf("certificate of %s for target %s %s", cert.Address, mt.Target.Name, problem)
+			log.WithField("target", mt.Target.Name).Warn(message)
+		}
+
+		if len(mt.Target.Notify) == 0 || !m.IsLeader() || m.InMaintenance(mt.Key) {
+			continue
+		}
+
+		m.notifierMu.RLock()
+		m.notifier.Notify(mt.Target.Notify, &notify.Event{
+			Kind:    notify.KIND_CERTIFICATE,
+			Subject: cert.Address,
+			Target:  mt.Target.Name,
+			Prefix:  mt.Target.Prefix,
+			Up:      problem == "",
+			Message: message,
+		})
+		m.notifierMu.RUnlock()
+	}
+
+	mt.Status.updateCertificates(certs)
 }
 
-func watchTarget(m *monitoredTarget) {
-	for {
-		ticker := time.NewTicker(15 * time.Second)
+// IsLeader tells if this instance is allowed to mutate the store
+func (m *Monitor) IsLeader() bool {
+	return m.leader.Load()
+}
+
+// probe tests the servers of the target and returns the delay before it should be probed again
+func (m *Monitor) probe(mt *monitoredTarget) time.Duration {
+	aliveUrls := testTarget(mt.Target, time.Duration(mt.HealthCheck.Timeout))
+	if mt.Context.Err() != nil {
+		// The target was stopped during the probe
+		return 0
+	}
+
+	transitions := mt.Status.update(mt.Target.ServerURLs, aliveUrls, mt.HealthCheck)
+	for _, t := range transitions {
+		ev := &MonitorEvent{Type: EVENT_TARGET, Time: t.Time, Key: mt.Key, Target: mt.Target.Name, Server: t.Server, Up: t.Up}
+		if t.Server != "" {
+			ev.Type = EVENT_SERVER
+		}
+
+		m.events.Publish(ev)
+	}
+
+	if targetChanged(transitions) {
+		m.notify(mt, aliveUrls)
 
-		aliveUrls := testTarget(m.Target)
 		switch len(aliveUrls) {
 		case 0:
-			log.WithField("target", m.Target.Name).Infof("Target is DOWN (0/%d)", len(m.Target.ServerURLs))
+			log.WithField("target", mt.Target.Name).Infof("Target is DOWN (0/%d)", len(mt.Target.ServerURLs))
 		default:
-			upNb := len(m.Target.ServerURLs) - len(aliveUrls)
-			log.WithField("target", m.Target.Name).Infof("Target is UP (%d/%d)", upNb, len(m.Target.ServerURLs))
+			log.WithField("target", mt.Target.Name).Infof("Target is UP (%d/%d)", len(aliveUrls), len(mt.Target.ServerURLs))
 		}
+	}
 
-		select {
-		case <-m.Context.Done():
-			return
-
-		case <-ticker.C:
-		}
+	if mt.Status.startCertificatesCheck() {
+		go m.checkCertificates(mt)
 	}
+
+	return mt.Status.nextProbe(mt.HealthCheck)
 }
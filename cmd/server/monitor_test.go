@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/numkem/traffikey"
+	"github.com/numkem/traffikey/keymate"
+	"github.com/numkem/traffikey/notify"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHTTPTarget(t *testing.T) {
@@ -14,7 +25,7 @@ func TestHTTPTarget(t *testing.T) {
 		ServerURLs: []string{
 			"https://google.com",
 		},
-	})
+	}, time.Second)
 
 	assert.NotEmpty(t, aliveUrls, "testHTTPTarget should return one url")
 }
@@ -26,7 +37,248 @@ func TestHTTPTargetFailed(t *testing.T) {
 		ServerURLs: []string{
 			"http://falksjdfalskjfaldkjf.com",
 		},
-	})
+	}, time.Second)
 
 	assert.Empty(t, aliveUrls, "testHTTPTarget should not return any url")
 }
+
+// fakeManager is a store without any data
+type fakeManager struct {
+	keymate.KeymateConnector
+}
+
+func (f *fakeManager) GetHistory(ctx context.Context, key string) (*traffikey.TargetHistory, error) {
+	return nil, nil
+}
+
+func (f *fakeManager) Leader(ctx context.Context, election string) (string, error) {
+	return "leader-host", nil
+}
+
+func (f *fakeManager) Lock(ctx context.Context, prefixes []string) (func(), error) {
+	return func() {}, nil
+}
+
+// testConfig returns an empty configuration with its defaults
+func testConfig(t *testing.T) *traffikey.Config {
+	filename := filepath.Join(t.TempDir(), "traffikey.json")
+	require.NoError(t, os.WriteFile(filename, []byte("{}"), 0600))
+
+	cfg, err := traffikey.NewConfig(filename)
+	require.NoError(t, err)
+
+	return cfg
+}
+
+// testMonitor returns a monitor with an empty configuration that doesn't run its scheduler
+func testMonitor(t *testing.T) *Monitor {
+	cfg := testConfig(t)
+	notifier, _ := notify.NewNotifier(nil)
+	m := &Monitor{
+		cfg:            cfg,
+		currentTargets: &sync.Map{},
+		notifier:       notifier,
+		manager:        &fakeManager{},
+		events:         newEventBus(),
+		maintenance:    make(map[string]time.Time),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.scheduler = newScheduler(1, m.probe)
+
+	return m
+}
+
+func TestMonitorReload(t *testing.T) {
+	m := testMonitor(t)
+	defer m.Stop()
+
+	tgt := &traffikey.Target{Name: "a", Type: "http", Prefix: "traefik", Monitored: true, Source: "targets.yaml"}
+	m.reload(&traffikey.Config{Targets: []*traffikey.Target{tgt}})
+
+	v, ok := m.currentTargets.Load("traefik/http/a")
+	assert.True(t, ok, "target should be monitored")
+	first := v.(*monitoredTarget)
+
+	// The same target read from the state, with its defaults filled and
+	// without source, shouldn't restart it
+	same := &traffikey.Target{Name: "a", Type: "http", Prefix: "traefik", Monitored: true, Entrypoint: m.cfg.Traefik.DefaultEntrypoint, HealthCheck: &traffikey.HealthCheck{}}
+	m.reload(&traffikey.Config{Targets: []*traffikey.Target{same}})
+
+	v, _ = m.currentTargets.Load("traefik/http/a")
+	assert.Equal(t, first.ID, v.(*monitoredTarget).ID, "equivalent target should not be restarted")
+	first = v.(*monitoredTarget)
+
+	// Changing the target should restart it while keeping its status
+	changed := &traffikey.Target{Name: "a", Type: "http", Prefix: "traefik", Monitored: true, Rule: "Host(`a`)"}
+	added := &traffikey.Target{Name: "b", Type: "http", Prefix: "traefik", Monitored: true}
+	m.reload(&traffikey.Config{Targets: []*traffikey.Target{changed, added}})
+
+	v, ok = m.currentTargets.Load("traefik/http/a")
+	assert.True(t, ok, "changed target should still be monitored")
+	second := v.(*monitoredTarget)
+	assert.NotEqual(t, first.ID, second.ID, "changed target should be restarted")
+	assert.Same(t, first.Status, second.Status, "status should be kept between restarts")
+	assert.Error(t, first.Context.Err(), "previous goroutine should be cancelled")
+
+	_, ok = m.currentTargets.Load("traefik/http/b")
+	assert.True(t, ok, "added target should be monitored")
+
+	// Invalid health checks keep the previous targets instead of crashing
+	invalid := &traffikey.Target{Name: "c", Type: "http", Prefix: "traefik", Monitored: true, HealthCheck: &traffikey.HealthCheck{Interval: traffikey.Duration(-time.Second)}}
+	assert.Error(t, m.reload(&traffikey.Config{Targets: []*traffikey.Target{invalid}}))
+	_, ok = m.currentTargets.Load("traefik/http/a")
+	assert.True(t, ok, "previous targets should be kept")
+
+	// Removing a target stops it
+	m.reload(&traffikey.Config{Targets: []*traffikey.Target{added}})
+	_, ok = m.currentTargets.Load("traefik/http/a")
+	assert.False(t, ok, "removed target should not be monitored")
+	assert.Error(t, second.Context.Err(), "removed target should be cancelled")
+}
+
+func TestMonitorNotifierReload(t *testing.T) {
+	summaries := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Summary string `json:"summary"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		summaries <- payload.Summary
+	}))
+	defer srv.Close()
+
+	m := testMonitor(t)
+	defer m.Stop()
+	m.leader.Store(true)
+	cfgs := map[string]*traffikey.NotifierConfig{
+		"hook": {Kind: "webhook", URL: srv.URL, GroupWindow: traffikey.Duration(10 * time.Millisecond)},
+	}
+	require.NoError(t, m.setNotifier(cfgs))
+
+	mt := &monitoredTarget{Key: "traefik/http/web", Target: &traffikey.Target{Name: "web", Notify: []string{"hook"}, ServerURLs: []string{"http://a"}}}
+	m.notify(mt, nil)
+	assert.Equal(t, "target web is DOWN (0/1 servers alive)", <-summaries)
+
+	// The configuration is reloaded while the target is down
+	require.NoError(t, m.setNotifier(cfgs))
+	m.notify(mt, nil)
+	m.notify(mt, []string{"http://a"})
+
+	select {
+	case summary := <-summaries:
+		assert.Equal(t, "target web recovered (1/1 servers alive)", summary, "the failure shouldn't be notified again")
+	case <-time.After(time.Second):
+		t.Fatal("recovery wasn't sent after the reload")
+	}
+}
+
+func TestTargetStatusThresholds(t *testing.T) {
+	hc := (&traffikey.HealthCheck{
+		Interval:         traffikey.Duration(10 * time.Second),
+		Jitter:           traffikey.Duration(time.Nanosecond),
+		FailureThreshold: 2,
+		SuccessThreshold: 2,
+		MaxBackoff:       traffikey.Duration(time.Minute),
+	}).Merge(nil)
+	s := newTargetStatus("traefik/http/a", "a")
+	servers := []string{"http://a"}
+
+	assert.False(t, targetChanged(s.update(servers, nil, hc)), "a single failure is under the threshold")
+	assert.True(t, targetChanged(s.update(servers, nil, hc)), "target should go down after 2 failures")
+	assert.False(t, s.Up)
+	assert.Equal(t, 10*time.Second, s.nextProbe(hc))
+
+	s.update(servers, nil, hc)
+	assert.Equal(t, 20*time.Second, s.nextProbe(hc), "down targets should back off")
+
+	for i := 0; i < 5; i++ {
+		s.update(servers, nil, hc)
+	}
+	assert.Equal(t, time.Minute, s.nextProbe(hc), "backoff should be capped")
+
+	transitions := s.update(servers, servers, hc)
+	assert.False(t, targetChanged(transitions), "a single success is under the threshold")
+	assert.Len(t, transitions, 1, "server should be back up")
+	assert.True(t, targetChanged(s.update(servers, servers, hc)), "target should recover after 2 successes")
+	assert.True(t, s.Up)
+
+	incidents := s.History.Incidents()
+	require.Len(t, incidents, 1)
+	assert.False(t, incidents[0].End.IsZero(), "incident should be over")
+	uptime, _ := s.History.Uptime("", time.Hour, time.Now())
+	assert.Equal(t, 20.0, uptime, "2 of the 10 probes succeeded")
+}
+
+func TestSchedulerWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var running, maxRunning, probes int
+	s := newScheduler(2, func(mt *monitoredTarget) time.Duration {
+		mu.Lock()
+		running++
+		probes++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+
+		return time.Hour
+	})
+	go s.run(ctx)
+
+	for i := 0; i < 6; i++ {
+		s.schedule(&monitoredTarget{Context: ctx}, time.Now())
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return probes == 6
+	}, time.Second, 5*time.Millisecond, "all targets should be probed")
+	assert.Equal(t, 2, maxRunning, "probes should be bounded by the number of workers")
+}
+
+// historyManager returns the same stored history for every target
+type historyManager struct {
+	fakeManager
+	stored *traffikey.TargetHistory
+}
+
+func (f *historyManager) GetHistory(ctx context.Context, key string) (*traffikey.TargetHistory, error) {
+	return f.stored.Copy(), nil
+}
+
+func TestLoadHistoryOnce(t *testing.T) {
+	now := time.Now()
+	stored := &traffikey.TargetHistory{Key: "traefik/http/web"}
+	stored.RecordProbe(now, "", true)
+	stored.RecordProbe(now, "", false)
+	stored.RecordTransition(now, "", false)
+
+	m := testMonitor(t)
+	defer m.Stop()
+	m.manager = &historyManager{stored: stored}
+
+	status := newTargetStatus("traefik/http/web", "web")
+	status.History.RecordProbe(now, "", true)
+
+	m.loadHistory(status, false)
+	m.loadHistory(status, false)
+	require.Len(t, status.History.Buckets, 1)
+	assert.Equal(t, 3, status.History.Buckets[0].Probes, "the stored history is only merged once")
+	assert.Len(t, status.History.Transitions, 1)
+	assert.Equal(t, "web", status.History.Name)
+
+	status.History.RecordProbe(now, "", true)
+	m.loadHistory(status, true)
+	assert.Equal(t, 2, status.History.Buckets[0].Probes, "the leader takes over the stored history")
+	assert.Len(t, status.History.Transitions, 1)
+}
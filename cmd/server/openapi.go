@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	traffikey "github.com/numkem/traffikey"
+)
+
+var (
+	echoParamRegexp = regexp.MustCompile(`:([a-z]+)`)
+
+	durationType = reflect.TypeOf(traffikey.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// apiError is the body of the error responses
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// openAPISchema returns the JSON schema of the type. Structs are added to the
+// components and referenced.
+func openAPISchema(t reflect.Type, components map[string]interface{}) map[string]interface{} {
+	switch t {
+	case durationType:
+		return map[string]interface{}{"type": "string", "example": "30s"}
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return openAPISchema(t.Elem(), components)
+
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), components)}
+
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": openAPISchema(t.Elem(), components)}
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+
+	case reflect.Struct:
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := components[t.Name()]; ok {
+			return ref
+		}
+
+		properties := make(map[string]interface{})
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		// Registered before the fields in case the type is recursive
+		components[t.Name()] = schema
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = openAPISchema(field.Type, components)
+		}
+
+		return ref
+	}
+
+	return map[string]interface{}{}
+}
+
+// openAPIDocument describes the routes of the API
+func openAPIDocument(routes []*apiRoute) map[string]interface{} {
+	components := make(map[string]interface{})
+	paths := make(map[string]interface{})
+
+	for _, route := range routes {
+		path := echoParamRegexp.ReplaceAllString(route.Path, "{$1}")
+
+		var parameters []interface{}
+		for _, param := range echoParamRegexp.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     param[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+
+		response := map[string]interface{}{"description": http.StatusText(route.Status)}
+		if route.Response != nil {
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": openAPISchema(route.Response, components)},
+			}
+		}
+
+		operation := map[string]interface{}{
+			"summary": route.Summary,
+			"responses": map[string]interface{}{
+				strconv.Itoa(route.Status): response,
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": openAPISchema(reflect.TypeOf(apiError{}), components)},
+					},
+				},
+			},
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": openAPISchema(route.Request, components)},
+				},
+			}
+		}
+
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "traffikey",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": components,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearer": []interface{}{}},
+		},
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/numkem/traffikey"
+)
+
+func TestRenderTargets(t *testing.T) {
+	t.Setenv("TRAFFIKEY_TEST_PASSWORD", "hunter2")
+
+	filename := filepath.Join(t.TempDir(), "traffikey.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{
+  "traefik": {"default_prefix": "traefik", "default_entrypoint": "web"},
+  "monitor": {"health_check": {"interval": "1m"}},
+  "templates": {"monitored": {"monitored": true, "health_check": {"timeout": "5s"}}},
+  "targets": [
+    {
+      "name": "web",
+      "extends": "monitored",
+      "rule": "Host(`+"`web`"+`)",
+      "urls": ["http://10.0.0.1"],
+      "middlewares": [{"name": "auth", "kind": "basicauth", "values": {"users": "admin:${TRAFFIKEY_TEST_PASSWORD}"}}]
+    },
+    {"name": "other", "rule": "Host(`+"`other`"+`)", "urls": ["http://10.0.0.2"]}
+  ]
+}`), 0o644))
+
+	cfg, err := traffikey.NewConfig(filename)
+	require.NoError(t, err)
+
+	targets := renderTargets(cfg, []string{"web"})
+	require.Len(t, targets, 1)
+	assert.Equal(t, "traefik", targets[0].Prefix)
+	assert.Equal(t, "http", targets[0].Type)
+	assert.Equal(t, "web", targets[0].Entrypoint)
+	assert.Equal(t, traffikey.Duration(60e9), targets[0].HealthCheck.Interval)
+	assert.Equal(t, traffikey.Duration(5e9), targets[0].HealthCheck.Timeout)
+
+	b, err := encodeTargets(targets, "yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "# "+filename+":6\n")
+	assert.Contains(t, string(b), "admin:${TRAFFIKEY_TEST_PASSWORD}", "secrets are shown as their expression")
+	assert.NotContains(t, string(b), "hunter2")
+}
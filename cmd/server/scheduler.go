@@ -0,0 +1,162 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+const DEFAULT_MONITOR_WORKERS = 10
+
+type scheduledProbe struct {
+	mt    *monitoredTarget
+	next  time.Time
+	index int
+}
+
+// probeQueue is a min-heap of probes ordered by the time they are due
+type probeQueue []*scheduledProbe
+
+func (q probeQueue) Len() int           { return len(q) }
+func (q probeQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q probeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *probeQueue) Push(x interface{}) {
+	p := x.(*scheduledProbe)
+	p.index = len(*q)
+	*q = append(*q, p)
+}
+
+func (q *probeQueue) Pop() interface{} {
+	old := *q
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+
+	return p
+}
+
+// scheduler runs the probes of the monitored targets when they are due using
+// a bounded pool of workers. The probe function returns the delay before the
+// target should be probed again.
+type scheduler struct {
+	workers int
+	probe   func(mt *monitoredTarget) time.Duration
+
+	mu    sync.Mutex
+	queue probeQueue
+	wake  chan struct{}
+	jobs  chan *monitoredTarget
+}
+
+func newScheduler(workers int, probe func(mt *monitoredTarget) time.Duration) *scheduler {
+	if workers <= 0 {
+		workers = DEFAULT_MONITOR_WORKERS
+	}
+
+	return &scheduler{
+		workers: workers,
+		probe:   probe,
+		wake:    make(chan struct{}, 1),
+		jobs:    make(chan *monitoredTarget),
+	}
+}
+
+// schedule queues the probe of the target at the given time
+func (s *scheduler) schedule(mt *monitoredTarget, at time.Time) {
+	s.mu.Lock()
+	heap.Push(&s.queue, &scheduledProbe{mt: mt, next: at})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next pops the next due probe. When none is due, it returns the delay to wait for one.
+func (s *scheduler) next(now time.Time) (*monitoredTarget, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.queue.Len() > 0 {
+		p := s.queue[0]
+
+		// Targets that were stopped or restarted are dropped
+		if p.mt.Context.Err() != nil {
+			heap.Pop(&s.queue)
+			continue
+		}
+
+		if p.next.After(now) {
+			return nil, p.next.Sub(now)
+		}
+
+		heap.Pop(&s.queue)
+		return p.mt, 0
+	}
+
+	return nil, -1
+}
+
+func (s *scheduler) run(ctx context.Context) {
+	for i := 0; i < s.workers; i++ {
+		go s.work(ctx)
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		mt, wait := s.next(time.Now())
+		if mt != nil {
+			select {
+			case s.jobs <- mt:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		var due <-chan time.Time
+		if wait >= 0 {
+			timer.Reset(wait)
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-due:
+		}
+	}
+}
+
+func (s *scheduler) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case mt := <-s.jobs:
+			delay := s.probe(mt)
+			if mt.Context.Err() == nil {
+				s.schedule(mt, time.Now().Add(delay))
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+
+	traffikey "github.com/numkem/traffikey"
+)
+
+// Delay to wait after the last change of the configuration file before reloading it
+const CONFIG_RELOAD_DEBOUNCE = 500 * time.Millisecond
+
+// configPaths returns what to watch for changes of the configuration: its
+// files and the directory of the configuration given as a directory or glob
+func configPaths(configFilename string, cfg *traffikey.Config) []string {
+	paths := append([]string{}, cfg.Files()...)
+	if info, err := os.Stat(configFilename); err == nil && info.IsDir() {
+		paths = append(paths, configFilename)
+	} else if strings.ContainsAny(configFilename, "*?[") {
+		paths = append(paths, filepath.Dir(configFilename))
+	}
+
+	return paths
+}
+
+// watchFile sends on the returned channel every time one of the files
+// changed, or a configuration file of one of the directories, once the
+// changes settled. The channel is closed when the context is done.
+func watchFile(ctx context.Context, paths ...string) (<-chan struct{}, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %v", err)
+	}
+
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	watched := make(map[string]bool)
+	for _, path := range paths {
+		path = filepath.Clean(path)
+
+		// Watch the directory since editors usually replace the file instead of writing to it
+		dir := filepath.Dir(path)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dir = path
+			dirs[path] = true
+		} else {
+			files[path] = true
+		}
+
+		if watched[dir] {
+			continue
+		}
+		watched[dir] = true
+
+		err = watcher.Add(dir)
+		if err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch file %s: %v", path, err)
+		}
+	}
+
+	changes := make(chan struct{})
+	go func() {
+		defer close(changes)
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				name := filepath.Clean(ev.Name)
+				changed := files[name] || (dirs[filepath.Dir(name)] && traffikey.IsConfigFile(name))
+				if changed && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0 {
+					debounce = time.After(CONFIG_RELOAD_DEBOUNCE)
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				log.Warnf("error while watching configuration: %v", err)
+
+			case <-debounce:
+				select {
+				case changes <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
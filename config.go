@@ -4,45 +4,202 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Targets []*Target      `json:"targets"`
-	Etcd    *etcdConfig    `json:"etcd"`
-	Traefik *traefikConfig `json:"traefik"`
+	Targets   []*Target                  `json:"targets"`
+	Etcd      *etcdConfig                `json:"etcd,omitempty"`
+	Traefik   *traefikConfig             `json:"traefik"`
+	Notifiers map[string]*NotifierConfig `json:"notifiers"`
+	Monitor   *monitorConfig             `json:"monitor"`
+	// Tokens allowed to use the HTTP APIs by name
+	Tokens map[string]*TokenConfig `json:"tokens"`
+	// Fail when a ${...} expression has no value instead of using an empty one
+	StrictInterpolation bool `json:"strict_interpolation,omitempty"`
+
+	interpolation *interpolation
+	// Files the configuration was read from
+	files []string
 }
 
 type etcdConfig struct {
 	Endpoints []string `json:"endpoints"`
-	SSL       bool     `json:"ssl"`
+	// Use TLS with the system's certificate authorities, implied by the other TLS fields
+	SSL      bool   `json:"ssl"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// File of the certificate authorities of the server, PEM encoded
+	CACert string `json:"ca_cert,omitempty"`
+	// Files of the client certificate and key, PEM encoded
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// Name checked in the certificate of the server instead of the one of the endpoint
+	ServerName string `json:"server_name,omitempty"`
+	// How long to wait for the connection, 5s by default
+	DialTimeout Duration `json:"dial_timeout,omitempty"`
+	// Ping the server after this long without activity and wait this long for its answer
+	KeepAliveTime    Duration `json:"keepalive_time,omitempty"`
+	KeepAliveTimeout Duration `json:"keepalive_timeout,omitempty"`
+	// Refresh the endpoints from the members of the cluster at this interval
+	AutoSyncInterval Duration `json:"auto_sync_interval,omitempty"`
+	// How long to wait for the locks of the store held by other processes
+	LockTimeout Duration `json:"lock_timeout"`
+	// Number of revisions of the state kept in the store, 100 by default
+	RevisionsKept int `json:"revisions_kept,omitempty"`
+}
+
+// Prefix of the environment variables overriding the etcd configuration
+const ETCD_ENV_PREFIX = "TRAFFIKEY_ETCD_"
+
+// applyEnv overrides the configuration with the TRAFFIKEY_ETCD_* environment variables
+func (c *etcdConfig) applyEnv() error {
+	values := map[string]*string{
+		"USERNAME":    &c.Username,
+		"PASSWORD":    &c.Password,
+		"CA_CERT":     &c.CACert,
+		"CERT":        &c.Cert,
+		"KEY":         &c.Key,
+		"SERVER_NAME": &c.ServerName,
+	}
+	for name, field := range values {
+		if value, ok := os.LookupEnv(ETCD_ENV_PREFIX + name); ok {
+			*field = value
+		}
+	}
+
+	durations := map[string]*Duration{
+		"DIAL_TIMEOUT":       &c.DialTimeout,
+		"KEEPALIVE_TIME":     &c.KeepAliveTime,
+		"KEEPALIVE_TIMEOUT":  &c.KeepAliveTimeout,
+		"AUTO_SYNC_INTERVAL": &c.AutoSyncInterval,
+		"LOCK_TIMEOUT":       &c.LockTimeout,
+	}
+	for name, field := range durations {
+		value, ok := os.LookupEnv(ETCD_ENV_PREFIX + name)
+		if !ok {
+			continue
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration in %s%s: %v", ETCD_ENV_PREFIX, name, err)
+		}
+		*field = Duration(d)
+	}
+
+	if value, ok := os.LookupEnv(ETCD_ENV_PREFIX + "ENDPOINTS"); ok {
+		c.Endpoints = nil
+		for _, endpoint := range strings.Split(value, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				c.Endpoints = append(c.Endpoints, endpoint)
+			}
+		}
+	}
+
+	if value, ok := os.LookupEnv(ETCD_ENV_PREFIX + "SSL"); ok {
+		ssl, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean in %sSSL: %v", ETCD_ENV_PREFIX, err)
+		}
+		c.SSL = ssl
+	}
+
+	return nil
+}
+
+// TLS returns whether the connection to etcd uses TLS
+func (c *etcdConfig) TLS() bool {
+	return c.SSL || c.CACert != "" || c.Cert != "" || c.ServerName != ""
+}
+
+type monitorConfig struct {
+	// Alert this many days before a certificate expires
+	CertificateWarningDays int `json:"certificate_warning_days"`
+	// Maximum number of targets probed at the same time
+	Workers int `json:"workers"`
+	// Default health check of the monitored targets
+	HealthCheck *HealthCheck `json:"health_check"`
 }
 
 type traefikConfig struct {
 	DefaultPrefix     string `json:"default_prefix"`
 	DefaultEntrypoint string `json:"default_entrypoint"`
+	// Names usable in the prefixes of targets for several prefixes
+	PrefixGroups map[string][]string `json:"prefix_groups,omitempty"`
 }
 
+// NewConfig reads the configuration from a file, the .json, .yaml and .yml
+// files of a directory or the files matching a glob, along with the files
+// they include, and applies the templates the targets extend
 func NewConfig(filename string) (*Config, error) {
-	log.WithField("filename", filename).Debugf("reading configuration file")
+	loader := newConfigLoader()
+	err := loader.load(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyTemplates(loader.doc, loader.sources)
+	if err != nil {
+		return nil, err
+	}
 
-	f, err := os.Open(filename)
+	cfg := new(Config)
+	err = decodeConfig(loader.doc, cfg)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("configuration file %s doesn't exists", filename)
+		return nil, fmt.Errorf("failed to interpolate %s: %v", filename, err)
+	}
+
+	cfg.files = loader.files
+	for i, tgt := range cfg.Targets {
+		if tgt != nil && i < len(loader.sources) {
+			tgt.Source = loader.sources[i]
 		}
+	}
+
+	cfg.SetDefaults()
 
-		return nil, fmt.Errorf("failed to read configuration file %s: %v", filename, err)
+	err = cfg.Etcd.applyEnv()
+	if err != nil {
+		return nil, err
 	}
 
-	cfg := new(Config)
-	err = json.NewDecoder(f).Decode(cfg)
+	if (cfg.Etcd.Cert == "") != (cfg.Etcd.Key == "") {
+		return nil, fmt.Errorf("etcd client certificate and key must be given together")
+	}
+
+	for name, token := range cfg.Tokens {
+		err = token.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid token %s: %v", name, err)
+		}
+	}
+
+	err = cfg.expandTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.checkDuplicates()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.ValidateHealthChecks(cfg.Monitor.HealthCheck)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode JSON: %v", err)
+		return nil, err
 	}
 
-	// Make are all parts of the config aren't nil
+	return cfg, nil
+}
+
+// SetDefaults makes sure that no part of the configuration is nil
+func (cfg *Config) SetDefaults() {
 	if cfg.Etcd == nil {
 		cfg.Etcd = new(etcdConfig)
 	}
@@ -52,6 +209,147 @@ func NewConfig(filename string) (*Config, error) {
 	if cfg.Traefik == nil {
 		cfg.Traefik = new(traefikConfig)
 	}
+	if cfg.Notifiers == nil {
+		cfg.Notifiers = make(map[string]*NotifierConfig)
+	}
+	if cfg.Monitor == nil {
+		cfg.Monitor = new(monitorConfig)
+	}
+	if cfg.Tokens == nil {
+		cfg.Tokens = make(map[string]*TokenConfig)
+	}
+}
 
-	return cfg, nil
+// expandTargets replaces the targets having several prefixes by a copy for
+// each of them, with the overrides of the prefix applied
+func (cfg *Config) expandTargets() error {
+	for name, prefixes := range cfg.Traefik.PrefixGroups {
+		if len(prefixes) == 0 {
+			return fmt.Errorf("prefix group %s is empty", name)
+		}
+	}
+
+	targets := make([]*Target, 0, len(cfg.Targets))
+	for _, tgt := range cfg.Targets {
+		if len(tgt.Prefixes) == 0 {
+			if len(tgt.Overrides) > 0 {
+				return fmt.Errorf("target %s has overrides without prefixes", tgt.Name)
+			}
+
+			targets = append(targets, tgt)
+			continue
+		}
+
+		if tgt.Prefix != "" {
+			return fmt.Errorf("target %s can't have both a prefix and prefixes", tgt.Name)
+		}
+
+		seen := make(map[string]bool)
+		for _, name := range tgt.Prefixes {
+			seen[name] = true
+			for _, prefix := range cfg.Traefik.PrefixGroups[name] {
+				seen[prefix] = true
+			}
+		}
+		for name := range tgt.Overrides {
+			if !seen[name] {
+				return fmt.Errorf("override %s of target %s isn't one of its prefixes", name, tgt.Name)
+			}
+		}
+
+		seen = make(map[string]bool)
+		for _, name := range tgt.Prefixes {
+			// Either a group or a prefix
+			group, ok := cfg.Traefik.PrefixGroups[name]
+			if !ok {
+				group = []string{name}
+			}
+
+			for _, prefix := range group {
+				if seen[prefix] {
+					continue
+				}
+				seen[prefix] = true
+
+				t := *tgt
+				t.Prefix = prefix
+				t.Prefixes = nil
+				t.Overrides = nil
+
+				// The overrides of the prefix win over the ones of its group
+				tgt.Overrides[name].apply(&t)
+				if name != prefix {
+					tgt.Overrides[prefix].apply(&t)
+				}
+
+				targets = append(targets, &t)
+			}
+		}
+	}
+	cfg.Targets = targets
+
+	return nil
+}
+
+// checkDuplicates refuses the targets written to the same keys as another one
+func (cfg *Config) checkDuplicates() error {
+	sources := make(map[string]string)
+	for _, tgt := range cfg.Targets {
+		prefix, tgtType := tgt.Prefix, tgt.Type
+		if prefix == "" {
+			prefix = cfg.Traefik.DefaultPrefix
+		}
+		if tgtType == "" {
+			tgtType = "http"
+		}
+
+		key := fmt.Sprintf("%s/%s/%s", prefix, tgtType, tgt.Name)
+		if source, ok := sources[key]; ok {
+			return fmt.Errorf("target %s is defined in both %s and %s", key, source, tgt.Source)
+		}
+		sources[key] = tgt.Source
+	}
+
+	return nil
+}
+
+// Files returns the files the configuration was read from, in order
+func (cfg *Config) Files() []string {
+	return cfg.files
+}
+
+// Prefixes returns the default prefix followed by the other prefixes used by the targets
+func (cfg *Config) Prefixes() []string {
+	seen := map[string]bool{cfg.Traefik.DefaultPrefix: true}
+	prefixes := []string{cfg.Traefik.DefaultPrefix}
+	for _, tgt := range cfg.Targets {
+		if tgt.Prefix != "" && !seen[tgt.Prefix] {
+			seen[tgt.Prefix] = true
+			prefixes = append(prefixes, tgt.Prefix)
+		}
+	}
+
+	return prefixes
+}
+
+// IsYAML returns whether the filename has a YAML extension
+func IsYAML(filename string) bool {
+	ext := filepath.Ext(filename)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// MarshalYAML encodes the value as YAML using its JSON names
+func MarshalYAML(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(doc)
 }
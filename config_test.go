@@ -0,0 +1,409 @@
+package traffikey
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConfigYAML(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traffikey.yaml")
+	err := os.WriteFile(filename, []byte(`
+traefik:
+  default_prefix: traefik
+  default_entrypoint: web
+targets:
+  - name: web
+    rule: Host(`+"`web`"+`)
+    urls: [http://10.0.0.1]
+    health_check:
+      interval: 30s
+`), 0o644)
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "web", cfg.Traefik.DefaultEntrypoint)
+	require.Len(t, cfg.Targets, 1)
+	assert.Equal(t, []string{"http://10.0.0.1"}, cfg.Targets[0].ServerURLs)
+	assert.Equal(t, Duration(30e9), cfg.Targets[0].HealthCheck.Interval)
+
+	b, err := MarshalYAML(cfg.Targets[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "interval: 30s")
+}
+
+func TestNewConfigPrefixes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traffikey.json")
+	err := os.WriteFile(filename, []byte(`{
+  "traefik": {
+    "default_prefix": "internal",
+    "default_entrypoint": "web",
+    "prefix_groups": {"edge": ["edge-a", "edge-b"]}
+  },
+  "targets": [
+    {"name": "local", "rule": "Host(`+"`local`"+`)", "urls": ["http://10.0.0.1"]},
+    {
+      "name": "web",
+      "rule": "Host(`+"`web`"+`)",
+      "urls": ["http://10.0.0.2"],
+      "prefixes": ["internal", "edge"],
+      "overrides": {
+        "edge": {"entrypoint": "websecure", "tls": true, "tls_extra_keys": {"certresolver": "le"}},
+        "edge-b": {"entrypoint": "public"}
+      }
+    }
+  ]
+}`), 0o644)
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(filename)
+	require.NoError(t, err)
+	require.Len(t, cfg.Targets, 4)
+	assert.Equal(t, "", cfg.Targets[0].Prefix, "targets with a single prefix are kept")
+
+	web := cfg.Targets[1:]
+	assert.Equal(t, []string{"internal", "edge-a", "edge-b"}, []string{web[0].Prefix, web[1].Prefix, web[2].Prefix})
+	assert.Equal(t, []string{"", "websecure", "public"}, []string{web[0].Entrypoint, web[1].Entrypoint, web[2].Entrypoint})
+	assert.False(t, web[0].TLS)
+	assert.True(t, web[2].TLS, "overrides of the group apply to its prefixes")
+	assert.Equal(t, map[string]string{"certresolver": "le"}, web[1].TLSExtraKeys)
+	assert.Nil(t, web[0].Prefixes)
+	assert.Equal(t, []string{"internal", "edge-a", "edge-b"}, cfg.Prefixes())
+
+	err = os.WriteFile(filename, []byte(`{"targets": [{"name": "web", "prefix": "a", "prefixes": ["b"]}]}`), 0o644)
+	require.NoError(t, err)
+	_, err = NewConfig(filename)
+	assert.Error(t, err, "a prefix and prefixes")
+
+	err = os.WriteFile(filename, []byte(`{"targets": [{"name": "web", "prefixes": ["b"], "overrides": {"c": {}}}]}`), 0o644)
+	require.NoError(t, err)
+	_, err = NewConfig(filename)
+	assert.Error(t, err, "override of another prefix")
+}
+
+func TestNewConfigInterpolation(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(secret, []byte("hunter2\n"), 0o600))
+	t.Setenv("TRAFFIKEY_TEST_HOST", "web.example.com")
+
+	filename := filepath.Join(dir, "traffikey.yaml")
+	err := os.WriteFile(filename, []byte(`
+traefik:
+  default_prefix: traefik
+  default_entrypoint: ${TRAFFIKEY_TEST_ENTRYPOINT:-web}
+targets:
+  - name: web
+    rule: Host(`+"`${TRAFFIKEY_TEST_HOST}`"+`)
+    urls: [http://10.0.0.1]
+    middlewares:
+      - name: auth
+        kind: basicauth
+        values:
+          users: admin:${file:`+secret+`}
+          realm: $${literal}
+`), 0o644)
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "web", cfg.Traefik.DefaultEntrypoint)
+	assert.Equal(t, "Host(`web.example.com`)", cfg.Targets[0].Rule)
+	assert.Equal(t, "admin:hunter2", cfg.Targets[0].Middlewares[0].Values["users"])
+	assert.Equal(t, "${literal}", cfg.Targets[0].Middlewares[0].Values["realm"])
+	assert.Equal(t, "users=admin:***", cfg.Redact("users=admin:hunter2"))
+
+	// The state keeps the expressions instead of the secrets
+	redacted := cfg.Redacted()
+	assert.Equal(t, "admin:${file:"+secret+"}", redacted.Targets[0].Middlewares[0].Values["users"])
+	assert.Equal(t, "$${literal}", redacted.Targets[0].Middlewares[0].Values["realm"])
+	assert.Equal(t, "admin:hunter2", cfg.Targets[0].Middlewares[0].Values["users"])
+
+	require.NoError(t, redacted.Interpolate())
+	assert.Equal(t, "admin:hunter2", redacted.Targets[0].Middlewares[0].Values["users"])
+	assert.Equal(t, "${literal}", redacted.Targets[0].Middlewares[0].Values["realm"])
+
+	tgt, err := cfg.Redacted().InterpolateTarget(cfg.Redacted().Targets[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Host(`web.example.com`)", tgt.Rule)
+}
+
+func TestNewConfigHealthChecks(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traffikey.yaml")
+	write := func(doc string) {
+		require.NoError(t, os.WriteFile(filename, []byte(doc), 0o644))
+	}
+
+	write(`
+monitor:
+  health_check:
+    interval: 30s
+targets:
+  - name: web
+    rule: Host(` + "`web`" + `)
+    health_check:
+      failure_threshold: 3
+`)
+	_, err := NewConfig(filename)
+	require.NoError(t, err)
+
+	write(`
+targets:
+  - name: web
+    rule: Host(` + "`web`" + `)
+    health_check:
+      interval: -5s
+`)
+	_, err = NewConfig(filename)
+	assert.ErrorContains(t, err, "interval must be positive")
+
+	write(`
+monitor:
+  health_check:
+    success_threshold: -1
+`)
+	_, err = NewConfig(filename)
+	assert.ErrorContains(t, err, "success_threshold cannot be negative")
+}
+
+func TestNewConfigStrictInterpolation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traffikey.json")
+	err := os.WriteFile(filename, []byte(`{
+  "strict_interpolation": true,
+  "traefik": {"default_prefix": "traefik", "default_entrypoint": "${TRAFFIKEY_TEST_MISSING}"}
+}`), 0o644)
+	require.NoError(t, err)
+
+	_, err = NewConfig(filename)
+	assert.ErrorContains(t, err, "environment variable TRAFFIKEY_TEST_MISSING is missing")
+
+	err = os.WriteFile(filename, []byte(`{
+  "traefik": {"default_prefix": "traefik", "default_entrypoint": "${TRAFFIKEY_TEST_MISSING:?set the entrypoint}"}
+}`), 0o644)
+	require.NoError(t, err)
+
+	_, err = NewConfig(filename)
+	assert.ErrorContains(t, err, "set the entrypoint")
+
+	err = os.WriteFile(filename, []byte(`{
+  "traefik": {"default_prefix": "traefik", "default_entrypoint": "${TRAFFIKEY_TEST_MISSING}"}
+}`), 0o644)
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "${TRAFFIKEY_TEST_MISSING}", cfg.Traefik.DefaultEntrypoint, "missing variables are kept as they are")
+}
+
+func TestInterpolateRegexReplacements(t *testing.T) {
+	t.Setenv("TRAFFIKEY_TEST_PORT", "8443")
+	t.Setenv("TRAFFIKEY_TEST_TOKEN", "a-long-secret")
+
+	filename := filepath.Join(t.TempDir(), "traffikey.yaml")
+	err := os.WriteFile(filename, []byte(`
+traefik:
+  default_prefix: traefik
+  default_entrypoint: web
+targets:
+  - name: web
+    rule: Host(`+"`web`"+`)
+    urls: ["http://10.0.0.1:${TRAFFIKEY_TEST_PORT}"]
+    middlewares:
+      - name: redirect
+        kind: redirectregex
+        values:
+          regex: ^http://(.*)
+          replacement: https://${1}:${TRAFFIKEY_TEST_PORT}/${path}?token=${TRAFFIKEY_TEST_TOKEN}
+`), 0o644)
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "https://${1}:8443/${path}?token=a-long-secret", cfg.Targets[0].Middlewares[0].Values["replacement"])
+	assert.Equal(t, "http://10.0.0.1:8443", cfg.Targets[0].ServerURLs[0])
+	assert.Equal(t, "listening on *** with ***", cfg.Redact("listening on 8443 with a-long-secret"), "every interpolated value is redacted")
+
+	assert.False(t, HasInterpolation("https://${1}/${0abc}/${a b}"))
+	assert.True(t, HasInterpolation("${path}"))
+	assert.True(t, HasInterpolation("${TOKEN:-default}"))
+	assert.False(t, HasInterpolation("$${TOKEN}"))
+
+	tgt, err := UnescapeTarget(&Target{Name: "web", Rule: "$${TRAFFIKEY_TEST_TOKEN}/${TRAFFIKEY_TEST_TOKEN}"})
+	require.NoError(t, err)
+	assert.Equal(t, "${TRAFFIKEY_TEST_TOKEN}/${TRAFFIKEY_TEST_TOKEN}", tgt.Rule, "targets of the APIs are only unescaped")
+}
+
+func TestNewConfigEtcdEnv(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traffikey.json")
+	err := os.WriteFile(filename, []byte(`{
+  "etcd": {"endpoints": ["http://127.0.0.1:2379"], "username": "file", "dial_timeout": "2s"},
+  "traefik": {"default_prefix": "traefik", "default_entrypoint": "web"}
+}`), 0o644)
+	require.NoError(t, err)
+
+	t.Setenv(ETCD_ENV_PREFIX+"ENDPOINTS", "https://etcd-1:2379, https://etcd-2:2379")
+	t.Setenv(ETCD_ENV_PREFIX+"PASSWORD", "secret")
+	t.Setenv(ETCD_ENV_PREFIX+"KEEPALIVE_TIME", "30s")
+
+	cfg, err := NewConfig(filename)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://etcd-1:2379", "https://etcd-2:2379"}, cfg.Etcd.Endpoints)
+	assert.Equal(t, "file", cfg.Etcd.Username)
+	assert.Equal(t, "secret", cfg.Etcd.Password)
+	assert.Equal(t, Duration(2e9), cfg.Etcd.DialTimeout)
+	assert.Equal(t, Duration(30e9), cfg.Etcd.KeepAliveTime)
+	assert.False(t, cfg.Etcd.TLS())
+	assert.Equal(t, REDACTED, cfg.Redacted().Etcd.Password, "the password isn't saved with the state")
+
+	t.Setenv(ETCD_ENV_PREFIX+"CERT", "/run/secrets/etcd.pem")
+	_, err = NewConfig(filename)
+	assert.ErrorContains(t, err, "certificate and key must be given together")
+}
+
+func TestNewConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "conf.d"), 0o755))
+
+	main := filepath.Join(dir, "traffikey.json")
+	require.NoError(t, os.WriteFile(main, []byte(`{
+  "include": "conf.d",
+  "traefik": {"default_prefix": "traefik", "default_entrypoint": "web"},
+  "targets": [
+    {"name": "global", "rule": "Host(`+"`global`"+`)", "urls": ["http://10.0.0.1"]}
+  ]
+}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "a.json"), []byte(`{
+  "targets": [
+    {"name": "a1", "rule": "Host(`+"`a1`"+`)", "urls": ["http://10.0.0.2"]},
+    {
+      "name": "a2",
+      "rule": "Host(`+"`a2`"+`)",
+      "urls": ["http://10.0.0.3"]
+    }
+  ]
+}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "b.yaml"), []byte(`
+targets:
+  - name: b1
+    rule: Host(`+"`b1`"+`)
+    urls: [http://10.0.0.4]
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "README.md"), []byte("not a configuration"), 0o644))
+
+	cfg, err := NewConfig(main)
+	require.NoError(t, err)
+	require.Len(t, cfg.Targets, 4)
+	assert.Equal(t, main+":5", cfg.Targets[0].Source)
+	assert.Equal(t, filepath.Join(dir, "conf.d", "a.json")+":3", cfg.Targets[1].Source)
+	assert.Equal(t, filepath.Join(dir, "conf.d", "a.json")+":4", cfg.Targets[2].Source)
+	assert.Equal(t, filepath.Join(dir, "conf.d", "b.yaml")+":3", cfg.Targets[3].Source)
+	assert.Len(t, cfg.Files(), 3)
+
+	// The directory can be given without the main file, which then lacks the traefik section
+	cfg, err = NewConfig(filepath.Join(dir, "conf.d", "*.yaml"))
+	require.NoError(t, err)
+	assert.Len(t, cfg.Targets, 1)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "c.json"), []byte(`{
+  "targets": [{"name": "a2", "rule": "Host(`+"`other`"+`)", "urls": ["http://10.0.0.5"]}]
+}`), 0o644))
+	_, err = NewConfig(main)
+	assert.ErrorContains(t, err, "target traefik/http/a2 is defined in both "+filepath.Join(dir, "conf.d", "a.json")+":4 and "+filepath.Join(dir, "conf.d", "c.json")+":2")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "c.json"), []byte(`{
+  "traefik": {"default_prefix": "other"}
+}`), 0o644))
+	_, err = NewConfig(main)
+	assert.ErrorContains(t, err, "traefik is set in both")
+}
+
+func TestNewConfigTemplates(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "traffikey.yaml")
+	err := os.WriteFile(filename, []byte(`
+traefik:
+  default_prefix: traefik
+  default_entrypoint: web
+templates:
+  web:
+    tls: true
+    tls_extra_keys:
+      certresolver: le
+    middlewares:
+      - name: headers
+        kind: headers
+        values:
+          stsSeconds: "31536000"
+    monitored: true
+    health_check:
+      interval: 30s
+  public-web:
+    extends: web
+    entrypoint: websecure
+    middlewares:
+      - name: ratelimit
+        kind: ratelimit
+        values:
+          average: "100"
+targets:
+  - name: shop
+    extends: public-web
+    rule: Host(`+"`shop`"+`)
+    urls: [http://10.0.0.1]
+    tls_extra_keys:
+      domains/0/main: shop.example.com
+    middlewares:
+      - name: headers
+        values:
+          frameDeny: "true"
+    health_check:
+      timeout: 5s
+  - name: plain
+    rule: Host(`+"`plain`"+`)
+    urls: [http://10.0.0.2]
+`), 0o644)
+	require.NoError(t, err)
+
+	cfg, err := NewConfig(filename)
+	require.NoError(t, err)
+	require.Len(t, cfg.Targets, 2)
+
+	shop := cfg.Targets[0]
+	assert.Equal(t, "websecure", shop.Entrypoint)
+	assert.True(t, shop.TLS)
+	assert.True(t, shop.Monitored)
+	assert.Equal(t, map[string]string{"certresolver": "le", "domains/0/main": "shop.example.com"}, shop.TLSExtraKeys)
+	assert.Equal(t, Duration(30e9), shop.HealthCheck.Interval)
+	assert.Equal(t, Duration(5e9), shop.HealthCheck.Timeout)
+	require.Len(t, shop.Middlewares, 2, "middlewares are merged by name")
+	assert.Equal(t, "headers", shop.Middlewares[0].Kind)
+	assert.Equal(t, map[string]string{"stsSeconds": "31536000", "frameDeny": "true"}, shop.Middlewares[0].Values)
+	assert.Equal(t, "ratelimit", shop.Middlewares[1].Name)
+	assert.Equal(t, filename+":27", shop.Source)
+
+	plain := cfg.Targets[1]
+	assert.False(t, plain.TLS)
+	assert.Empty(t, plain.Middlewares)
+
+	err = os.WriteFile(filename, []byte(`
+templates:
+  a: {extends: b}
+  b: {extends: a}
+targets:
+  - {name: web, extends: a}
+`), 0o644)
+	require.NoError(t, err)
+	_, err = NewConfig(filename)
+	assert.ErrorContains(t, err, "templates extend each other: a -> b -> a")
+
+	err = os.WriteFile(filename, []byte(`
+targets:
+  - {name: web, extends: missing}
+`), 0o644)
+	require.NoError(t, err)
+	_, err = NewConfig(filename)
+	assert.ErrorContains(t, err, filename+":3: target web: unknown template missing")
+}
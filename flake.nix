@@ -88,6 +88,9 @@
               go
               gopls
               gotools
+              protobuf
+              protoc-gen-go
+              protoc-gen-go-grpc
             ];
           };
         }
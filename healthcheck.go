@@ -0,0 +1,114 @@
+package traffikey
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DEFAULT_HEALTHCHECK_INTERVAL    = 15 * time.Second
+	DEFAULT_HEALTHCHECK_TIMEOUT     = 1 * time.Second
+	DEFAULT_HEALTHCHECK_MAX_BACKOFF = 5 * time.Minute
+)
+
+// HealthCheck configures how the monitor probes a target
+type HealthCheck struct {
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+	// Random delay added to every interval so that targets aren't probed in lockstep
+	Jitter Duration `json:"jitter"`
+	// Consecutive failed probes before the target is considered down
+	FailureThreshold int `json:"failure_threshold"`
+	// Consecutive successful probes before the target is considered up again
+	SuccessThreshold int `json:"success_threshold"`
+	// The interval is doubled for every failed probe of a down target up to this delay
+	MaxBackoff Duration `json:"max_backoff"`
+}
+
+// Merge returns a copy of the health check where unset values are taken from
+// defaults, falling back on the built-in ones
+func (hc *HealthCheck) Merge(defaults *HealthCheck) *HealthCheck {
+	merged := &HealthCheck{}
+	for _, h := range []*HealthCheck{hc, defaults} {
+		if h == nil {
+			continue
+		}
+
+		if merged.Interval == 0 {
+			merged.Interval = h.Interval
+		}
+		if merged.Timeout == 0 {
+			merged.Timeout = h.Timeout
+		}
+		if merged.Jitter == 0 {
+			merged.Jitter = h.Jitter
+		}
+		if merged.FailureThreshold == 0 {
+			merged.FailureThreshold = h.FailureThreshold
+		}
+		if merged.SuccessThreshold == 0 {
+			merged.SuccessThreshold = h.SuccessThreshold
+		}
+		if merged.MaxBackoff == 0 {
+			merged.MaxBackoff = h.MaxBackoff
+		}
+	}
+
+	if merged.Interval == 0 {
+		merged.Interval = Duration(DEFAULT_HEALTHCHECK_INTERVAL)
+	}
+	if merged.Timeout == 0 {
+		merged.Timeout = Duration(DEFAULT_HEALTHCHECK_TIMEOUT)
+	}
+	if merged.Jitter == 0 {
+		merged.Jitter = merged.Interval / 10
+	}
+	if merged.FailureThreshold == 0 {
+		merged.FailureThreshold = 1
+	}
+	if merged.SuccessThreshold == 0 {
+		merged.SuccessThreshold = 1
+	}
+	if merged.MaxBackoff == 0 {
+		merged.MaxBackoff = Duration(DEFAULT_HEALTHCHECK_MAX_BACKOFF)
+	}
+
+	return merged
+}
+
+// Validate checks a health check merged with its defaults
+func (hc *HealthCheck) Validate() error {
+	switch {
+	case hc.Interval <= 0:
+		return fmt.Errorf("interval must be positive")
+	case hc.Timeout < 0:
+		return fmt.Errorf("timeout cannot be negative")
+	case hc.Jitter < 0:
+		return fmt.Errorf("jitter cannot be negative")
+	case hc.FailureThreshold < 0:
+		return fmt.Errorf("failure_threshold cannot be negative")
+	case hc.SuccessThreshold < 0:
+		return fmt.Errorf("success_threshold cannot be negative")
+	case hc.MaxBackoff < 0:
+		return fmt.Errorf("max_backoff cannot be negative")
+	}
+
+	return nil
+}
+
+// ValidateHealthChecks checks the health checks of the targets merged with the given defaults
+func (cfg *Config) ValidateHealthChecks(defaults *HealthCheck) error {
+	err := defaults.Merge(nil).Validate()
+	if err != nil {
+		return fmt.Errorf("invalid default health check: %v", err)
+	}
+
+	for _, tgt := range cfg.Targets {
+		err = tgt.HealthCheck.Merge(defaults).Validate()
+		if err != nil {
+			return fmt.Errorf("invalid health check of target %s: %v", tgt.Name, err)
+		}
+	}
+
+	return nil
+}
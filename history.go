@@ -0,0 +1,169 @@
+package traffikey
+
+import (
+	"sort"
+	"time"
+)
+
+// Probe history older than this is dropped when compacting
+const HISTORY_RETENTION = 30 * 24 * time.Hour
+
+// Transition is a change of state of a target or one of its servers
+type Transition struct {
+	Time time.Time `json:"time"`
+	// Empty for the target itself
+	Server string `json:"server,omitempty"`
+	Up     bool   `json:"up"`
+}
+
+// ProbeBucket counts the probes of a target or one of its servers during an hour
+type ProbeBucket struct {
+	Hour time.Time `json:"hour"`
+	// Empty for the target itself
+	Server    string `json:"server,omitempty"`
+	Probes    int    `json:"probes"`
+	Successes int    `json:"successes"`
+}
+
+// Incident is a period during which a target was down
+type Incident struct {
+	Start time.Time `json:"start"`
+	// Zero while the target is still down
+	End time.Time `json:"end,omitempty"`
+}
+
+func (i *Incident) Duration(now time.Time) time.Duration {
+	if i.End.IsZero() {
+		return now.Sub(i.Start)
+	}
+
+	return i.End.Sub(i.Start)
+}
+
+// TargetHistory is the persisted probe history of a monitored target
+type TargetHistory struct {
+	Key         string         `json:"key"`
+	Name        string         `json:"name"`
+	Transitions []*Transition  `json:"transitions"`
+	Buckets     []*ProbeBucket `json:"buckets"`
+}
+
+// Copy returns a deep copy of the history
+func (h *TargetHistory) Copy() *TargetHistory {
+	c := &TargetHistory{Key: h.Key, Name: h.Name}
+	for _, t := range h.Transitions {
+		transition := *t
+		c.Transitions = append(c.Transitions, &transition)
+	}
+	for _, b := range h.Buckets {
+		bucket := *b
+		c.Buckets = append(c.Buckets, &bucket)
+	}
+
+	return c
+}
+
+// RecordProbe counts the result of a probe of the target (empty server) or of one of its servers
+func (h *TargetHistory) RecordProbe(now time.Time, server string, success bool) {
+	hour := now.UTC().Truncate(time.Hour)
+
+	var bucket *ProbeBucket
+	for i := len(h.Buckets) - 1; i >= 0 && !h.Buckets[i].Hour.Before(hour); i-- {
+		if h.Buckets[i].Hour.Equal(hour) && h.Buckets[i].Server == server {
+			bucket = h.Buckets[i]
+			break
+		}
+	}
+
+	if bucket == nil {
+		bucket = &ProbeBucket{Hour: hour, Server: server}
+		h.Buckets = append(h.Buckets, bucket)
+	}
+
+	bucket.Probes++
+	if success {
+		bucket.Successes++
+	}
+}
+
+func (h *TargetHistory) RecordTransition(now time.Time, server string, up bool) {
+	h.Transitions = append(h.Transitions, &Transition{Time: now.UTC(), Server: server, Up: up})
+}
+
+// Compact drops what is older than the retention
+func (h *TargetHistory) Compact(now time.Time, retention time.Duration) {
+	limit := now.Add(-retention)
+
+	var transitions []*Transition
+	for _, t := range h.Transitions {
+		if !t.Time.Before(limit) {
+			transitions = append(transitions, t)
+		}
+	}
+	h.Transitions = transitions
+
+	var buckets []*ProbeBucket
+	for _, b := range h.Buckets {
+		if !b.Hour.Before(limit.Truncate(time.Hour)) {
+			buckets = append(buckets, b)
+		}
+	}
+	h.Buckets = buckets
+}
+
+// Uptime returns the percentage of successful probes of the target (empty server)
+// or of one of its servers during the window. false is returned without probes.
+func (h *TargetHistory) Uptime(server string, window time.Duration, now time.Time) (float64, bool) {
+	from := now.Add(-window).UTC().Truncate(time.Hour)
+
+	var probes, successes int
+	for _, b := range h.Buckets {
+		if b.Server == server && !b.Hour.Before(from) {
+			probes += b.Probes
+			successes += b.Successes
+		}
+	}
+
+	if probes == 0 {
+		return 0, false
+	}
+
+	return float64(successes) * 100 / float64(probes), true
+}
+
+// Servers returns the servers found in the history
+func (h *TargetHistory) Servers() []string {
+	seen := make(map[string]bool)
+	var servers []string
+	for _, b := range h.Buckets {
+		if b.Server != "" && !seen[b.Server] {
+			seen[b.Server] = true
+			servers = append(servers, b.Server)
+		}
+	}
+
+	sort.Strings(servers)
+	return servers
+}
+
+// Incidents returns the periods during which the target was down, oldest first
+func (h *TargetHistory) Incidents() []*Incident {
+	var incidents []*Incident
+	var current *Incident
+	for _, t := range h.Transitions {
+		if t.Server != "" {
+			continue
+		}
+
+		switch {
+		case !t.Up && current == nil:
+			current = &Incident{Start: t.Time}
+			incidents = append(incidents, current)
+		case t.Up && current != nil:
+			current.End = t.Time
+			current = nil
+		}
+	}
+
+	return incidents
+}
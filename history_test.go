@@ -0,0 +1,52 @@
+package traffikey
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryUptime(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 30, 0, 0, time.UTC)
+	h := &TargetHistory{}
+
+	// A day ago, all probes failed
+	for i := 0; i < 4; i++ {
+		h.RecordProbe(now.Add(-36*time.Hour), "", false)
+	}
+	// Last hour, half of them did
+	for i := 0; i < 4; i++ {
+		h.RecordProbe(now, "", i%2 == 0)
+	}
+	h.RecordProbe(now, "http://a", true)
+
+	uptime, ok := h.Uptime("", 24*time.Hour, now)
+	assert.True(t, ok)
+	assert.Equal(t, 50.0, uptime)
+
+	uptime, _ = h.Uptime("", 7*24*time.Hour, now)
+	assert.Equal(t, 25.0, uptime)
+
+	_, ok = h.Uptime("http://b", 24*time.Hour, now)
+	assert.False(t, ok, "server without probes has no uptime")
+	assert.Equal(t, []string{"http://a"}, h.Servers())
+
+	h.Compact(now, 24*time.Hour)
+	uptime, _ = h.Uptime("", 7*24*time.Hour, now)
+	assert.Equal(t, 50.0, uptime, "compaction should drop old probes")
+}
+
+func TestHistoryIncidents(t *testing.T) {
+	now := time.Now()
+	h := &TargetHistory{}
+	h.RecordTransition(now.Add(-time.Hour), "", false)
+	h.RecordTransition(now.Add(-50*time.Minute), "http://a", true)
+	h.RecordTransition(now.Add(-30*time.Minute), "", true)
+	h.RecordTransition(now.Add(-10*time.Minute), "", false)
+
+	incidents := h.Incidents()
+	assert.Len(t, incidents, 2)
+	assert.Equal(t, 30*time.Minute, incidents[0].Duration(now))
+	assert.True(t, incidents[1].End.IsZero(), "last incident is still ongoing")
+}
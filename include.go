@@ -0,0 +1,340 @@
+package traffikey
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Sections of the configuration whose entries can be spread between files
+var mergedSections = map[string]bool{"notifiers": true, "tokens": true, "templates": true}
+
+// configLoader merges the files of a configuration into a single document
+type configLoader struct {
+	doc map[string]interface{}
+	// Files read so far, in order
+	files []string
+	// file:line of each target of the document
+	sources []string
+	// File that set each section, or each entry of the merged sections
+	origins map[string]string
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{
+		doc:     make(map[string]interface{}),
+		origins: make(map[string]string),
+	}
+}
+
+// IsConfigFile returns whether the file has the extension of a configuration
+func IsConfigFile(filename string) bool {
+	return IsYAML(filename) || filepath.Ext(filename) == ".json"
+}
+
+// configFiles returns the files of the path: the file itself, the
+// configuration files of a directory or the files matching a glob
+func configFiles(path string) ([]string, error) {
+	if strings.ContainsAny(path, "*?[") {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration pattern %s: %v", path, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no configuration file matches %s", path)
+		}
+
+		sort.Strings(matches)
+		return matches, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("configuration file %s doesn't exists", path)
+		}
+
+		return nil, fmt.Errorf("failed to read configuration file %s: %v", path, err)
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration directory %s: %v", path, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && IsConfigFile(entry.Name()) {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("configuration directory %s has no .json, .yaml or .yml file", path)
+	}
+
+	return files, nil
+}
+
+// load merges the files of the path, in order
+func (l *configLoader) load(path string) error {
+	files, err := configFiles(path)
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range files {
+		err = l.loadFile(filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFile merges the file then the ones it includes, relative to its directory
+func (l *configLoader) loadFile(filename string) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	for _, f := range l.files {
+		if other, _ := filepath.Abs(f); other == abs {
+			return fmt.Errorf("configuration file %s is included more than once", filename)
+		}
+	}
+	l.files = append(l.files, filename)
+
+	log.WithField("filename", filename).Debugf("reading configuration file")
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file %s: %v", filename, err)
+	}
+
+	var doc interface{}
+	var lines []int
+	if IsYAML(filename) {
+		err = yaml.Unmarshal(data, &doc)
+		if err == nil {
+			lines = yamlTargetLines(data)
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&doc)
+		if err == nil {
+			lines = jsonTargetLines(data)
+		}
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to decode %s: %v", filename, err)
+	}
+
+	// Empty files are allowed
+	if doc == nil {
+		return nil
+	}
+
+	sections, ok := doc.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("failed to decode %s: the configuration should be an object", filename)
+	}
+
+	includes, err := stringList(sections["include"])
+	if err != nil {
+		return fmt.Errorf("invalid include of %s: %v", filename, err)
+	}
+	delete(sections, "include")
+
+	err = l.merge(filename, sections, lines)
+	if err != nil {
+		return err
+	}
+
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filename), include)
+		}
+
+		err = l.load(include)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+
+	return nil
+}
+
+// merge adds the sections of the file to the document
+func (l *configLoader) merge(filename string, sections map[string]interface{}, lines []int) error {
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := sections[name]
+
+		switch {
+		case name == "targets":
+			targets, ok := value.([]interface{})
+			if !ok && value != nil {
+				return fmt.Errorf("failed to decode %s: targets should be a list", filename)
+			}
+
+			existing, _ := l.doc["targets"].([]interface{})
+			l.doc["targets"] = append(existing, targets...)
+			for i := range targets {
+				source := filename
+				if i < len(lines) {
+					source = fmt.Sprintf("%s:%d", filename, lines[i])
+				}
+				l.sources = append(l.sources, source)
+			}
+
+		case mergedSections[name]:
+			entries, ok := value.(map[string]interface{})
+			if !ok && value != nil {
+				return fmt.Errorf("failed to decode %s: %s should be an object", filename, name)
+			}
+
+			merged, _ := l.doc[name].(map[string]interface{})
+			if merged == nil {
+				merged = make(map[string]interface{})
+				l.doc[name] = merged
+			}
+
+			for key, entry := range entries {
+				origin, ok := l.origins[name+"."+key]
+				if ok {
+					return fmt.Errorf("%s %s is defined in both %s and %s", name, key, origin, filename)
+				}
+
+				l.origins[name+"."+key] = filename
+				merged[key] = entry
+			}
+
+		default:
+			origin, ok := l.origins[name]
+			if ok {
+				return fmt.Errorf("%s is set in both %s and %s, it can only be set in one file", name, origin, filename)
+			}
+
+			l.origins[name] = filename
+			l.doc[name] = value
+		}
+	}
+
+	return nil
+}
+
+// stringList reads a value that is either a string or a list of strings
+func stringList(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+
+	case string:
+		return []string{v}, nil
+
+	case []interface{}:
+		var ss []string
+		for _, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("%v isn't a string", e)
+			}
+			ss = append(ss, s)
+		}
+		return ss, nil
+	}
+
+	return nil, fmt.Errorf("should be a string or a list of strings")
+}
+
+// jsonTargetLines returns the line where each target of the JSON document starts
+func jsonTargetLines(data []byte) []int {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil || tok != json.Delim('{') {
+		return nil
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+
+		if key != "targets" {
+			var skipped json.RawMessage
+			if dec.Decode(&skipped) != nil {
+				return nil
+			}
+			continue
+		}
+
+		tok, err = dec.Token()
+		if err != nil || tok != json.Delim('[') {
+			return nil
+		}
+
+		var lines []int
+		for dec.More() {
+			// The offset is the one of the end of the previous token
+			start := int(dec.InputOffset())
+			start += len(data[start:]) - len(bytes.TrimLeft(data[start:], " \t\r\n,"))
+			lines = append(lines, 1+bytes.Count(data[:start], []byte("\n")))
+
+			var skipped json.RawMessage
+			if dec.Decode(&skipped) != nil {
+				return nil
+			}
+		}
+
+		return lines
+	}
+
+	return nil
+}
+
+// yamlTargetLines returns the line where each target of the YAML document starts
+func yamlTargetLines(data []byte) []int {
+	var root yaml.Node
+	if yaml.Unmarshal(data, &root) != nil || len(root.Content) == 0 {
+		return nil
+	}
+
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "targets" || doc.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+
+		var lines []int
+		for _, target := range doc.Content[i+1].Content {
+			lines = append(lines, target.Line)
+		}
+		return lines
+	}
+
+	return nil
+}
@@ -0,0 +1,438 @@
+package traffikey
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Replaces the interpolated values in the output of traffikey
+const REDACTED = "***"
+
+// interpolation holds what was replaced while interpolating a configuration
+type interpolation struct {
+	strict bool
+	// Only unescape $${, the expressions are kept as they are
+	literal bool
+	// Templates of the interpolated strings by their value
+	templates map[string]string
+	// Values inserted in the strings
+	secrets []string
+}
+
+// isExpression returns whether the content of ${...} is one traffikey
+// interpolates: file:/path or an environment variable name, optionally
+// followed by :-default or :?message. Others, like the ${1} of the
+// replacements of traefik's regex middlewares, are left as they are.
+func isExpression(expr string) bool {
+	if strings.HasPrefix(expr, "file:") {
+		return true
+	}
+
+	name, rest, _ := strings.Cut(expr, ":")
+	if rest != "" || strings.HasSuffix(expr, ":") {
+		if !strings.HasPrefix(rest, "-") && !strings.HasPrefix(rest, "?") {
+			return false
+		}
+	}
+
+	for i, c := range name {
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !isLetter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// HasInterpolation returns whether the string holds ${...} expressions
+func HasInterpolation(s string) bool {
+	for rest := s; ; {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			return false
+		}
+
+		end := strings.Index(rest[i:], "}")
+		if end < 0 {
+			return false
+		}
+
+		escaped := i > 0 && rest[i-1] == '$'
+		if !escaped && isExpression(rest[i+2:i+end]) {
+			return true
+		}
+		rest = rest[i+2:]
+	}
+}
+
+// lookup returns the value of the expression of ${expression}:
+//   - NAME, the environment variable
+//   - file:/path, the content of the file without its trailing newline
+//
+// followed by :-default to use when the value is missing or :?message to fail.
+// A missing environment variable is kept as ${NAME} unless the interpolation is strict.
+func (in *interpolation) lookup(expr string) (string, error) {
+	if in.literal {
+		return "${" + expr + "}", nil
+	}
+
+	name, fallback, hasDefault := strings.Cut(expr, ":-")
+	var required string
+	isRequired := false
+	if !hasDefault {
+		name, required, isRequired = strings.Cut(expr, ":?")
+	}
+
+	// file: would be cut as a default otherwise
+	isFile := strings.HasPrefix(expr, "file:")
+	if isFile {
+		rest := strings.TrimPrefix(expr, "file:")
+		path, d, ok := strings.Cut(rest, ":-")
+		fallback, hasDefault = d, ok
+		if !ok {
+			path, required, isRequired = strings.Cut(rest, ":?")
+		}
+
+		b, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r"), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		name = "file " + path
+	} else if value, ok := os.LookupEnv(name); ok {
+		return value, nil
+	} else {
+		name = "environment variable " + name
+	}
+
+	switch {
+	case hasDefault:
+		return fallback, nil
+	case isRequired && required != "":
+		return "", fmt.Errorf("%s is missing: %s", name, required)
+	case isRequired || in.strict:
+		return "", fmt.Errorf("%s is missing", name)
+	case isFile:
+		log.Warnf("%s is missing, using an empty value", name)
+		return "", nil
+	}
+
+	// Names like ${path} are usually the groups of a regex of traefik
+	if strings.ToUpper(expr) == expr {
+		log.Warnf("%s is missing, keeping ${%s} as it is", name, expr)
+	} else {
+		log.Debugf("%s is missing, keeping ${%s} as it is", name, expr)
+	}
+	return "${" + expr + "}", nil
+}
+
+// interpolateString replaces the ${...} expressions of the string, $${ is kept as ${
+func (in *interpolation) interpolateString(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	rest := s
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			b.WriteString(rest)
+			break
+		}
+
+		if i > 0 && rest[i-1] == '$' {
+			b.WriteString(rest[:i-1] + "${")
+			rest = rest[i+2:]
+			continue
+		}
+
+		end := strings.Index(rest[i:], "}")
+		if end < 0 {
+			b.WriteString(rest)
+			break
+		}
+
+		expr := rest[i+2 : i+end]
+		if !isExpression(expr) {
+			b.WriteString(rest[:i+end+1])
+			rest = rest[i+end+1:]
+			continue
+		}
+
+		value, err := in.lookup(expr)
+		if err != nil {
+			return "", err
+		}
+
+		// Any interpolated value can be a secret, whatever its length
+		if value != "" && value != "${"+expr+"}" {
+			in.secrets = append(in.secrets, value)
+		}
+
+		b.WriteString(rest[:i] + value)
+		rest = rest[i+end+1:]
+	}
+
+	// Escaped strings are kept too so that redacting them escapes them again
+	value := b.String()
+	if value != s && value != "" {
+		in.templates[value] = s
+	}
+
+	return value, nil
+}
+
+// interpolateDocument replaces the ${...} expressions of every string of the decoded document
+func (in *interpolation) interpolateDocument(doc interface{}) (interface{}, error) {
+	switch v := doc.(type) {
+	case string:
+		return in.interpolateString(v)
+
+	case map[string]interface{}:
+		for key, value := range v {
+			replaced, err := in.interpolateDocument(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			v[key] = replaced
+		}
+
+	case []interface{}:
+		for i, value := range v {
+			replaced, err := in.interpolateDocument(value)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", i, err)
+			}
+			v[i] = replaced
+		}
+	}
+
+	return doc, nil
+}
+
+// replaceStrings returns the document with the strings replaced by the function
+func replaceStrings(doc interface{}, replace func(string) string) interface{} {
+	switch v := doc.(type) {
+	case string:
+		return replace(v)
+
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = replaceStrings(value, replace)
+		}
+
+	case []interface{}:
+		for i, value := range v {
+			v[i] = replaceStrings(value, replace)
+		}
+	}
+
+	return doc
+}
+
+// decodeConfig decodes the configuration from its document after interpolating it
+func decodeConfig(doc interface{}, cfg *Config) error {
+	in := &interpolation{templates: make(map[string]string)}
+	if m, ok := doc.(map[string]interface{}); ok {
+		in.strict, _ = m["strict_interpolation"].(bool)
+	}
+
+	doc, err := in.interpolateDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		return err
+	}
+
+	if len(in.templates) > 0 || len(in.secrets) > 0 {
+		cfg.interpolation = in
+	}
+
+	return nil
+}
+
+// Interpolate replaces the ${...} expressions of the configuration, like the
+// ones of a state that was saved with its secrets redacted
+func (cfg *Config) Interpolate() error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return err
+	}
+
+	interpolated := new(Config)
+	err = decodeConfig(doc, interpolated)
+	if err != nil {
+		return err
+	}
+	interpolated.SetDefaults()
+
+	*cfg = *interpolated
+	return nil
+}
+
+// InterpolateTarget returns a copy of the target, taken from a saved state,
+// with its ${...} expressions replaced
+func (cfg *Config) InterpolateTarget(t *Target) (*Target, error) {
+	return interpolateTarget(t, &interpolation{strict: cfg.StrictInterpolation, templates: make(map[string]string)})
+}
+
+// UnescapeTarget returns a copy of the target, taken from the body of an API
+// call, with its $${ replaced by ${. Its ${...} expressions are kept as they
+// are, only the configuration files can read the environment and files.
+func UnescapeTarget(t *Target) (*Target, error) {
+	return interpolateTarget(t, &interpolation{literal: true, templates: make(map[string]string)})
+}
+
+// EscapeTarget returns a copy of the target where every ${ is written $${,
+// interpolating it gives back the target
+func EscapeTarget(t *Target) (*Target, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	doc = replaceStrings(doc, func(s string) string {
+		return strings.ReplaceAll(s, "${", "$${")
+	})
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	escaped := new(Target)
+	err = json.Unmarshal(b, escaped)
+	if err != nil {
+		return nil, err
+	}
+
+	return escaped, nil
+}
+
+func interpolateTarget(t *Target, in *interpolation) (*Target, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err = in.interpolateDocument(doc)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: %v", t.Name, err)
+	}
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	interpolated := new(Target)
+	err = json.Unmarshal(b, interpolated)
+	if err != nil {
+		return nil, err
+	}
+
+	return interpolated, nil
+}
+
+// Redacted returns a copy of the configuration where the interpolated strings
+// are replaced by their ${...} expressions and the password of etcd is
+// hidden, the configuration itself when there is nothing to redact
+func (cfg *Config) Redacted() *Config {
+	hasPassword := cfg.Etcd != nil && cfg.Etcd.Password != ""
+	if (cfg.interpolation == nil || len(cfg.interpolation.templates) == 0) && !hasPassword {
+		return cfg
+	}
+
+	templates := map[string]string{}
+	if cfg.interpolation != nil {
+		templates = cfg.interpolation.templates
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg
+	}
+
+	var doc interface{}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		return cfg
+	}
+
+	doc = replaceStrings(doc, func(s string) string {
+		if template, ok := templates[s]; ok {
+			return template
+		}
+		return s
+	})
+
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return cfg
+	}
+
+	redacted := new(Config)
+	err = json.Unmarshal(b, redacted)
+	if err != nil {
+		return cfg
+	}
+
+	// Only kept when it is read from the environment or a file
+	if hasPassword && !HasInterpolation(redacted.Etcd.Password) {
+		redacted.Etcd.Password = REDACTED
+	}
+
+	return redacted
+}
+
+// Redact replaces the interpolated values found in the string, for logs
+func (cfg *Config) Redact(s string) string {
+	if cfg == nil || cfg.interpolation == nil {
+		return s
+	}
+
+	// Longest first so that values holding others are replaced whole
+	secrets := append([]string{}, cfg.interpolation.secrets...)
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, REDACTED)
+	}
+
+	return s
+}
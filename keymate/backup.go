@@ -0,0 +1,201 @@
+package keymate
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	etcd "go.etcd.io/etcd/client/v3"
+)
+
+// Version of the format of the backups
+const BACKUP_VERSION = 1
+
+// BackupHeader is the first line of a backup
+type BackupHeader struct {
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"`
+	Prefixes []string  `json:"prefixes"`
+	// Revision of etcd at which the keys were read
+	EtcdRevision int64 `json:"etcd_revision"`
+}
+
+// BackupEntry is a key of a backup, one per line after the header
+type BackupEntry struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	ModRevision int64  `json:"mod_revision"`
+}
+
+// backupPrefixes returns the prefixes and traffikey's own, each only once
+func backupPrefixes(prefixes []string) []string {
+	var all []string
+	for _, prefix := range append(slices.Clone(prefixes), ETCD_CONFIG_PREFIX) {
+		if !slices.Contains(all, prefix) {
+			all = append(all, prefix)
+		}
+	}
+
+	return all
+}
+
+// readPrefixes returns the keys under the prefixes at the same revision. Keys
+// attached to a lease, like locks and the keys of agents, are left out.
+func (m *EtcdKeymateManager) readPrefixes(ctx context.Context, prefixes []string) ([]*BackupEntry, int64, error) {
+	var rev int64
+	entries := make(map[string]*BackupEntry)
+	for _, prefix := range prefixes {
+		opts := []etcd.OpOption{etcd.WithPrefix()}
+		if rev != 0 {
+			opts = append(opts, etcd.WithRev(rev))
+		}
+
+		resp, err := m.client.Get(ctx, prefix+"/", opts...)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to get keys of prefix %s: %v", prefix, err)
+		}
+		rev = resp.Header.Revision
+
+		for _, kv := range resp.Kvs {
+			if kv.Lease != 0 {
+				continue
+			}
+
+			entries[string(kv.Key)] = &BackupEntry{Key: string(kv.Key), Value: string(kv.Value), ModRevision: kv.ModRevision}
+		}
+	}
+
+	sorted := make([]*BackupEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	return sorted, rev, nil
+}
+
+// Backup writes the keys under the prefixes and the ones of traffikey as JSON lines
+func (m *EtcdKeymateManager) Backup(ctx context.Context, prefixes []string, w io.Writer) (*BackupHeader, error) {
+	prefixes = backupPrefixes(prefixes)
+
+	entries, rev, err := m.readPrefixes(ctx, prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &BackupHeader{Version: BACKUP_VERSION, Time: time.Now(), Prefixes: prefixes, EtcdRevision: rev}
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write backup: %v", err)
+	}
+
+	for _, entry := range entries {
+		err = enc.Encode(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write backup: %v", err)
+		}
+	}
+
+	return header, nil
+}
+
+// ReadBackup reads a backup written by Backup
+func ReadBackup(r io.Reader) (*BackupHeader, []*BackupEntry, error) {
+	scanner := bufio.NewScanner(r)
+	// Values like the state can be long
+	scanner.Buffer(nil, 64*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, nil, fmt.Errorf("failed to read backup: %v", err)
+		}
+		return nil, nil, fmt.Errorf("backup is empty")
+	}
+
+	header := new(BackupHeader)
+	err := json.Unmarshal(scanner.Bytes(), header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid backup header: %v", err)
+	}
+	if header.Version != BACKUP_VERSION {
+		return nil, nil, fmt.Errorf("unsupported backup version %d", header.Version)
+	}
+
+	var entries []*BackupEntry
+	for line := 2; scanner.Scan(); line++ {
+		entry := new(BackupEntry)
+		err := json.Unmarshal(scanner.Bytes(), entry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid backup entry on line %d: %v", line, err)
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read backup: %v", err)
+	}
+
+	return header, entries, nil
+}
+
+// restorePlan compares the keys of the backup to the live ones. When
+// replacing, the live keys missing from the backup are deleted.
+func restorePlan(entries []*BackupEntry, live etcdKeyValue, replace bool) *Plan {
+	plan := new(Plan)
+
+	backup := make(map[string]bool)
+	for _, entry := range entries {
+		backup[entry.Key] = true
+
+		current, ok := live[entry.Key]
+		if !ok || current != entry.Value {
+			plan.Puts = append(plan.Puts, &KeyChange{Key: entry.Key, Current: current, Wanted: entry.Value})
+		}
+	}
+	sort.Slice(plan.Puts, func(i, j int) bool { return plan.Puts[i].Key < plan.Puts[j].Key })
+
+	if replace {
+		keys := make([]string, 0, len(live))
+		for key := range live {
+			if !backup[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			plan.Deletes = append(plan.Deletes, &KeyChange{Key: key, Current: live[key]})
+		}
+	}
+
+	return plan
+}
+
+// PlanRestore returns the changes that restoring the backup makes to the store
+func (m *EtcdKeymateManager) PlanRestore(ctx context.Context, header *BackupHeader, entries []*BackupEntry, replace bool) (*Plan, error) {
+	for _, entry := range entries {
+		if !slices.ContainsFunc(header.Prefixes, func(prefix string) bool { return strings.HasPrefix(entry.Key, prefix+"/") }) {
+			return nil, fmt.Errorf("key %s of the backup isn't under its prefixes", entry.Key)
+		}
+	}
+
+	current, _, err := m.readPrefixes(ctx, header.Prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(etcdKeyValue)
+	for _, entry := range current {
+		live[entry.Key] = entry.Value
+	}
+
+	return restorePlan(entries, live, replace), nil
+}
@@ -0,0 +1,47 @@
+package keymate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadBackup(t *testing.T) {
+	header, entries, err := ReadBackup(strings.NewReader(`{"version":1,"time":"2026-10-19T10:00:00Z","prefixes":["traefik","traefik/config"],"etcd_revision":42}
+{"key":"traefik/http/routers/web/rule","value":"Host(` + "`web`" + `)","mod_revision":40}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), header.EtcdRevision)
+	assert.Equal(t, []*BackupEntry{{Key: "traefik/http/routers/web/rule", Value: "Host(`web`)", ModRevision: 40}}, entries)
+
+	_, _, err = ReadBackup(strings.NewReader(`{"version":2}`))
+	assert.Error(t, err, "unknown versions are refused")
+	_, _, err = ReadBackup(strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestRestorePlan(t *testing.T) {
+	entries := []*BackupEntry{
+		{Key: "traefik/http/routers/web/rule", Value: "Host(`web`)"},
+		{Key: "traefik/http/routers/web/service", Value: "web"},
+		{Key: "traefik/http/services/web/loadbalancer/servers/0/url", Value: "http://10.0.0.1"},
+	}
+	live := etcdKeyValue{
+		"traefik/http/routers/web/rule":    "Host(`other`)",
+		"traefik/http/routers/web/service": "web",
+		"traefik/http/routers/new/rule":    "Host(`new`)",
+	}
+
+	plan := restorePlan(entries, live, false)
+	require.Len(t, plan.Puts, 2)
+	assert.Equal(t, &KeyChange{Key: "traefik/http/routers/web/rule", Current: "Host(`other`)", Wanted: "Host(`web`)"}, plan.Puts[0])
+	assert.Equal(t, "traefik/http/services/web/loadbalancer/servers/0/url", plan.Puts[1].Key)
+	assert.Empty(t, plan.Deletes, "merging keeps the other keys")
+
+	plan = restorePlan(entries, live, true)
+	assert.Len(t, plan.Puts, 2)
+	require.Len(t, plan.Deletes, 1)
+	assert.Equal(t, "traefik/http/routers/new/rule", plan.Deletes[0].Key)
+}
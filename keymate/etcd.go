@@ -2,20 +2,29 @@ package keymate
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	etcd "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
 	"golang.org/x/exp/maps"
 
 	"github.com/numkem/traffikey"
 )
 
 const (
-	ETCD_CONFIG_PREFIX = "traefik/config"
+	ETCD_CONFIG_PREFIX      = "traefik/config"
+	ETCD_STATE_PREFIX       = ETCD_CONFIG_PREFIX + "/state"
+	ETCD_ELECTION_PREFIX    = ETCD_CONFIG_PREFIX + "/election"
+	ETCD_HISTORY_PREFIX     = ETCD_CONFIG_PREFIX + "/history"
+	ETCD_MAINTENANCE_PREFIX = ETCD_CONFIG_PREFIX + "/maintenance"
 )
 
 type etcdKeyValue map[string]string
@@ -25,12 +34,78 @@ type EtcdKeymateManager struct {
 	cfg    *traffikey.Config
 }
 
-const TRAEFIK_DEFAULT_PREFIX = "traefik"
+const (
+	TRAEFIK_DEFAULT_PREFIX = "traefik"
+
+	// How long to wait for the connection to etcd when it isn't configured
+	ETCD_DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+)
+
+// etcdTLSConfig returns the TLS configuration of the connection to etcd, nil without TLS
+func etcdTLSConfig(cfg *traffikey.Config) (*tls.Config, error) {
+	if !cfg.Etcd.TLS() {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: cfg.Etcd.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.Etcd.CACert != "" {
+		b, err := os.ReadFile(cfg.Etcd.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read etcd CA certificate: %v", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in etcd CA certificate %s", cfg.Etcd.CACert)
+		}
+	}
+
+	if cfg.Etcd.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Etcd.Cert, cfg.Etcd.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load etcd client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// etcdClientConfig returns the configuration of the etcd client
+func etcdClientConfig(cfg *traffikey.Config) (etcd.Config, error) {
+	tlsConfig, err := etcdTLSConfig(cfg)
+	if err != nil {
+		return etcd.Config{}, err
+	}
+
+	dialTimeout := time.Duration(cfg.Etcd.DialTimeout)
+	if dialTimeout == 0 {
+		dialTimeout = ETCD_DEFAULT_DIAL_TIMEOUT
+	}
+
+	return etcd.Config{
+		Endpoints:            cfg.Etcd.Endpoints,
+		Username:             cfg.Etcd.Username,
+		Password:             cfg.Etcd.Password,
+		TLS:                  tlsConfig,
+		DialTimeout:          dialTimeout,
+		DialKeepAliveTime:    time.Duration(cfg.Etcd.KeepAliveTime),
+		DialKeepAliveTimeout: time.Duration(cfg.Etcd.KeepAliveTimeout),
+		AutoSyncInterval:     time.Duration(cfg.Etcd.AutoSyncInterval),
+	}, nil
+}
 
 func NewEtcdManager(cfg *traffikey.Config) (KeymateConnector, error) {
-	client, err := etcd.New(etcd.Config{
-		Endpoints: cfg.Etcd.Endpoints,
-	})
+	clientConfig, err := etcdClientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := etcd.New(clientConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to etcd: %v", err)
 	}
@@ -119,7 +194,8 @@ func valuesForMiddlewares(target *traffikey.Target, middlewares []*traffikey.Mid
 	return keys
 }
 
-func (m *EtcdKeymateManager) writeTarget(ctx context.Context, target *traffikey.Target) error {
+// keysForTarget returns the keys and values traefik needs for the target
+func keysForTarget(target *traffikey.Target) etcdKeyValue {
 	keys := etcdKeyValue{
 		fmt.Sprintf("%s/%s/routers/%s/entrypoints", target.Prefix, target.Type, target.Name): target.Entrypoint,
 		fmt.Sprintf("%s/%s/routers/%s/rule", target.Prefix, target.Type, target.Name):        target.Rule,
@@ -158,9 +234,13 @@ func (m *EtcdKeymateManager) writeTarget(ctx context.Context, target *traffikey.
 	// Apply all the middlewares
 	maps.Copy(keys, valuesForMiddlewares(target, target.Middlewares))
 
-	// Write the key/value
-	for key, value := range keys {
-		_, err := m.client.KV.Put(ctx, key, value)
+	return keys
+}
+
+// writeTarget puts the keys of the target, the options are given to every put
+func (m *EtcdKeymateManager) writeTarget(ctx context.Context, target *traffikey.Target, opts ...etcd.OpOption) error {
+	for key, value := range keysForTarget(target) {
+		_, err := m.client.KV.Put(ctx, key, value, opts...)
 		if err != nil {
 			if e := m.deleteTarget(ctx, target); e != nil {
 				log.Warnf("failed to cleanup the target after failed insertion: %v", e)
@@ -207,9 +287,216 @@ func (m *EtcdKeymateManager) ApplyConfig(ctx context.Context, cfg *traffikey.Con
 	return errs
 }
 
-func (m *EtcdKeymateManager) middlewaresForRouter(ctx context.Context, routerName string, prefix string) ([]*traffikey.Middleware, error) {
+// ApplyTarget replaces the keys of a single target, the target is validated
+// before any key is changed
+func (m *EtcdKeymateManager) ApplyTarget(ctx context.Context, target *traffikey.Target) error {
+	if err := m.validateTarget(target); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTarget, err)
+	}
+
+	// The middlewares the router used before are removed once they aren't used anymore
+	resp, err := m.client.Get(ctx, fmt.Sprintf("%s/%s/routers/%s/middlewares", target.Prefix, target.Type, target.Name))
+	if err != nil {
+		return fmt.Errorf("failed to get middlewares of target %s: %v", target.Name, err)
+	}
+
+	var previous []string
+	if len(resp.Kvs) > 0 {
+		previous = strings.Split(string(resp.Kvs[0].Value), ",")
+	}
+
+	err = m.DeleteTarget(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("target", target.Name).Debug("adding new target")
+
+	err = m.writeTarget(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	err = m.deleteUnusedMiddlewares(ctx, target.Prefix, target.Type, previous)
+	if err != nil {
+		return fmt.Errorf("failed to delete previous middlewares of target %s: %v", target.Name, err)
+	}
+
+	return nil
+}
+
+// DeleteTarget removes the keys of a target, including the ones of its
+// middlewares that no other router uses
+func (m *EtcdKeymateManager) DeleteTarget(ctx context.Context, target *traffikey.Target) error {
+	errs := m.deleteTarget(ctx, target)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	var names []string
+	for _, middleware := range target.Middlewares {
+		names = append(names, middleware.Name)
+	}
+
+	err := m.deleteUnusedMiddlewares(ctx, target.Prefix, target.Type, names)
+	if err != nil {
+		return fmt.Errorf("failed to delete middlewares of target %s: %v", target.Name, err)
+	}
+
+	return nil
+}
+
+// unusedMiddlewares returns the names that none of the routers reference,
+// from the keys under the routers prefix of a prefix and type
+func unusedMiddlewares(routerKeys map[string]string, routersPrefix string, names []string) []string {
+	used := make(map[string]bool)
+	for key, value := range routerKeys {
+		// Only <router>/middlewares holds the middlewares of a router
+		_, rest, ok := strings.Cut(strings.TrimPrefix(key, routersPrefix), "/")
+		if !ok || rest != "middlewares" {
+			continue
+		}
+
+		for _, name := range strings.Split(value, ",") {
+			used[strings.TrimSpace(name)] = true
+		}
+	}
+
+	var unused []string
+	for _, name := range names {
+		if !used[name] && !slices.Contains(unused, name) {
+			unused = append(unused, name)
+		}
+	}
+
+	return unused
+}
+
+// deleteUnusedMiddlewares removes the keys of the middlewares that no router
+// of the prefix and type references anymore, they are shared by name
+func (m *EtcdKeymateManager) deleteUnusedMiddlewares(ctx context.Context, prefix, routerType string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	routersPrefix := fmt.Sprintf("%s/%s/routers/", prefix, routerType)
+	resp, err := m.client.Get(ctx, routersPrefix, etcd.WithPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to get routers: %v", err)
+	}
+
+	routerKeys := make(map[string]string)
+	for _, kv := range resp.Kvs {
+		routerKeys[string(kv.Key)] = string(kv.Value)
+	}
+
+	for _, name := range unusedMiddlewares(routerKeys, routersPrefix, names) {
+		_, err := m.client.Delete(ctx, fmt.Sprintf("%s/%s/middlewares/%s/", prefix, routerType, name), etcd.WithPrefix())
+		if err != nil {
+			return fmt.Errorf("failed to delete middleware %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// registerTargets replaces the keys of the targets by ones attached to the lease
+func (m *EtcdKeymateManager) registerTargets(ctx context.Context, targets []*traffikey.Target, lease etcd.LeaseID) error {
+	for _, target := range targets {
+		err := m.DeleteTarget(ctx, target)
+		if err != nil {
+			return err
+		}
+
+		err = m.writeTarget(ctx, target, etcd.WithLease(lease))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterTargets writes the targets with keys attached to a lease kept alive
+// until the context is cancelled, when the keys are removed. true is sent on
+// the returned channel every time the targets are registered and false when
+// the lease is lost, in which case they are registered again.
+func (m *EtcdKeymateManager) RegisterTargets(ctx context.Context, targets []*traffikey.Target, ttl int) (<-chan bool, error) {
+	for _, target := range targets {
+		if err := m.validateTarget(target); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTarget, err)
+		}
+	}
+
+	registrations := make(chan bool)
+	retry := func() {
+		select {
+		case <-time.After(time.Duration(ttl) * time.Second):
+		case <-ctx.Done():
+		}
+	}
+	revoke := func(lease etcd.LeaseID) {
+		// The context can already be cancelled
+		revokeCtx, cancel := context.WithTimeout(context.Background(), time.Duration(ttl)*time.Second)
+		defer cancel()
+
+		_, err := m.client.Revoke(revokeCtx, lease)
+		if err != nil {
+			log.Warnf("failed to revoke lease %x: %v", lease, err)
+		}
+	}
+	send := func(registered bool) {
+		select {
+		case registrations <- registered:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		defer close(registrations)
+
+		for ctx.Err() == nil {
+			lease, err := m.client.Grant(ctx, int64(ttl))
+			if err != nil {
+				log.Warnf("failed to grant lease: %v", err)
+				retry()
+				continue
+			}
+
+			keepAlive, err := m.client.KeepAlive(ctx, lease.ID)
+			if err == nil {
+				err = m.registerTargets(ctx, targets, lease.ID)
+			}
+			if err != nil {
+				log.Warnf("failed to register targets: %v", err)
+				revoke(lease.ID)
+				retry()
+				continue
+			}
+
+			send(true)
+
+			// The channel is closed once the context is cancelled or the lease is lost
+			for range keepAlive {
+			}
+
+			if ctx.Err() != nil {
+				// Remove the keys right away instead of waiting for the lease to expire
+				revoke(lease.ID)
+				return
+			}
+
+			log.Warnf("lost lease %x of the registered targets", lease.ID)
+			send(false)
+		}
+	}()
+
+	return registrations, nil
+}
+
+func (m *EtcdKeymateManager) middlewaresForRouter(ctx context.Context, routerName string, routerType string, prefix string) ([]*traffikey.Middleware, error) {
 	// Fetch the middlewares names for this router
-	resp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/http/routers/%s/middlewares", prefix, routerName))
+	resp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/%s/routers/%s/middlewares", prefix, routerType, routerName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get middlewares key from etcd: %v", err)
 	}
@@ -220,65 +507,62 @@ func (m *EtcdKeymateManager) middlewaresForRouter(ctx context.Context, routerNam
 	}
 
 	// For each middleware, fetch it's config
-	middlewares := make(map[string]traffikey.Middleware)
+	var middlewares []*traffikey.Middleware
 	for _, middlewareName := range middlewareNames {
+		middlewarePrefix := fmt.Sprintf("%s/%s/middlewares/%s/", prefix, routerType, middlewareName)
+
 		// Get the key/value pair
-		mdResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/http/middlewares/%s", prefix, middlewareName), etcd.WithPrefix())
+		mdResp, err := m.client.KV.Get(ctx, middlewarePrefix, etcd.WithPrefix())
 		if err != nil {
 			return nil, fmt.Errorf("failed to get middleware keys from etcd: %v", err)
 		}
 
-		for _, key := range mdResp.Kvs {
-			ss := strings.Split(key.String(), "/")
-
-			if _, ok := middlewares[ss[3]]; !ok {
-				middlewares[ss[3]] = traffikey.Middleware{
-					Name: ss[3],
-					Kind: ss[4],
-					Values: map[string]string{
-						ss[5]: string(key.Value),
-					},
-				}
-			} else {
-				middlewares[ss[3]].Values[ss[5]] = string(key.Value)
-			}
+		middleware := &traffikey.Middleware{
+			Name:   middlewareName,
+			Values: make(map[string]string),
 		}
+		for _, kv := range mdResp.Kvs {
+			// The rest of the key is <kind>/<value key>
+			kind, key, ok := strings.Cut(strings.TrimPrefix(string(kv.Key), middlewarePrefix), "/")
+			if !ok {
+				continue
+			}
 
-	}
+			middleware.Kind = kind
+			middleware.Values[key] = string(kv.Value)
+		}
 
-	var values []*traffikey.Middleware
-	for _, v := range middlewares {
-		values = append(values, &v)
+		middlewares = append(middlewares, middleware)
 	}
 
-	return values, nil
+	return middlewares, nil
 }
 
-func (m *EtcdKeymateManager) targetFromRouter(ctx context.Context, routerName string, prefix string) (*traffikey.Target, error) {
+func (m *EtcdKeymateManager) targetFromRouter(ctx context.Context, routerName string, routerType string, prefix string) (*traffikey.Target, error) {
 	target := traffikey.Target{
 		Name:         routerName,
-		Type:         "",
+		Type:         routerType,
 		ServerURLs:   []string{},
 		Entrypoint:   "",
 		Middlewares:  []*traffikey.Middleware{},
-		Prefix:       m.cfg.Traefik.DefaultPrefix,
+		Prefix:       prefix,
 		Rule:         "",
 		TLS:          false,
 		TLSExtraKeys: map[string]string{},
 	}
 
-	serversResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/http/services/%s/loadbalancer/servers/", prefix, routerName))
+	serversResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/%s/services/%s/loadbalancer/servers/", prefix, routerType, routerName), etcd.WithPrefix())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get loadbalancer keys from etcd: %v", err)
 	}
-	for _, key := range serversResp.Kvs {
-		if strings.Contains(key.String(), "url") {
-			target.ServerURLs = append(target.ServerURLs, string(key.Value))
+	for _, kv := range serversResp.Kvs {
+		if strings.HasSuffix(string(kv.Key), "/url") || strings.HasSuffix(string(kv.Key), "/address") {
+			target.ServerURLs = append(target.ServerURLs, string(kv.Value))
 		}
 	}
 
 	// Check if the router has TLS
-	tlsResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/http/routers/%s/tls", prefix, routerName))
+	tlsResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/%s/routers/%s/tls", prefix, routerType, routerName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tls key from etcd: %v", err)
 	}
@@ -287,7 +571,7 @@ func (m *EtcdKeymateManager) targetFromRouter(ctx context.Context, routerName st
 	}
 
 	// Get the router's rules
-	ruleResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/http/routers/%s/rule", prefix, routerName))
+	ruleResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/%s/routers/%s/rule", prefix, routerType, routerName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get rule key from etcd: %v", err)
 	}
@@ -296,7 +580,7 @@ func (m *EtcdKeymateManager) targetFromRouter(ctx context.Context, routerName st
 	}
 
 	// Get the router's entrypoint
-	entryResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/http/routers/%s/entrypoints", prefix, routerName))
+	entryResp, err := m.client.KV.Get(ctx, fmt.Sprintf("%s/%s/routers/%s/entrypoints", prefix, routerType, routerName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get entrypoint key from etcd: %v", err)
 	}
@@ -304,44 +588,50 @@ func (m *EtcdKeymateManager) targetFromRouter(ctx context.Context, routerName st
 		target.Entrypoint = string(entryResp.Kvs[0].Value)
 	}
 
-	target.Middlewares, err = m.middlewaresForRouter(ctx, routerName, prefix)
+	target.Middlewares, err = m.middlewaresForRouter(ctx, routerName, routerType, prefix)
+	if err != nil {
+		return nil, err
+	}
 
 	return &target, nil
 }
 
 func (m *EtcdKeymateManager) ListTargets(ctx context.Context, cfg *traffikey.Config) ([]*traffikey.Target, error) {
-	resp, err := m.client.Get(ctx, cfg.Traefik.DefaultPrefix, etcd.WithPrefix())
+	prefix := cfg.Traefik.DefaultPrefix
+
+	resp, err := m.client.Get(ctx, prefix+"/", etcd.WithPrefix(), etcd.WithKeysOnly())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get targets from etcd: %v", err)
 	}
 
 	targets := make(map[string]*traffikey.Target)
-	for _, key := range resp.Kvs {
-		// Only pick the router keys
-		if strings.Contains(key.String(), "router") {
-			routerName := strings.Split(key.String(), "/")[3]
+	var values []*traffikey.Target
+	for _, kv := range resp.Kvs {
+		// Only pick the router keys: <type>/routers/<name>/...
+		ss := strings.SplitN(strings.TrimPrefix(string(kv.Key), prefix+"/"), "/", 4)
+		if len(ss) < 3 || ss[1] != "routers" {
+			continue
+		}
 
-			if _, ok := targets[routerName]; !ok {
-				t, err := m.targetFromRouter(ctx, routerName, cfg.Traefik.DefaultPrefix)
-				if err != nil {
-					return nil, fmt.Errorf("failed to get target for router %s from etcd: %v", routerName, err)
-				}
+		routerType, routerName := ss[0], ss[2]
+		if _, ok := targets[routerType+"/"+routerName]; ok {
+			continue
+		}
 
-				targets[routerName] = t
-			}
+		t, err := m.targetFromRouter(ctx, routerName, routerType, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get target for router %s from etcd: %v", routerName, err)
 		}
-	}
 
-	var values []*traffikey.Target
-	for _, v := range targets {
-		values = append(values, v)
+		targets[routerType+"/"+routerName] = t
+		values = append(values, t)
 	}
 
 	return values, nil
 }
 
 func (m *EtcdKeymateManager) ListTargetsByOwner(ctx context.Context, owner string) ([]*traffikey.Target, error) {
-	resp, err := m.client.Get(ctx, fmt.Sprintf("%s/%s", ETCD_CONFIG_PREFIX, owner))
+	resp, err := m.client.Get(ctx, stateKey(owner))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get targets from etcd: %v", err)
 	}
@@ -375,45 +665,273 @@ func (m *EtcdKeymateManager) DeleteTargetByName(ctx context.Context, target stri
 	return nil
 }
 
+// stateKey returns the key of the state of the host
+func stateKey(hostname string) string {
+	return fmt.Sprintf("%s/%s", ETCD_STATE_PREFIX, hostname)
+}
+
+// legacyStateKey returns the key the state of the host was saved to before
+// it had its own prefix, empty when the hostname is one of traffikey's keys
+func legacyStateKey(hostname string) string {
+	key := fmt.Sprintf("%s/%s", ETCD_CONFIG_PREFIX, hostname)
+	for _, reserved := range []string{ETCD_STATE_PREFIX, ETCD_ELECTION_PREFIX, ETCD_HISTORY_PREFIX, ETCD_MAINTENANCE_PREFIX, ETCD_LOCK_PREFIX, ETCD_REVISION_PREFIX} {
+		if key == reserved {
+			return ""
+		}
+	}
+
+	return key
+}
+
+// stateLocation returns the key of the state named on the context, or of
+// this host, and the key previous versions saved it to, empty if none
+func stateLocation(ctx context.Context) (string, string, error) {
+	name, host, err := stateNameOf(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !host {
+		return stateKey(name), "", nil
+	}
+
+	return stateKey(name), legacyStateKey(name), nil
+}
+
+// GetState returns the state of this host, or the one named on the context.
+// The state saved by previous versions is read until it is moved by the next save.
 func (m *EtcdKeymateManager) GetState(ctx context.Context) (*traffikey.Config, error) {
-	hostname, err := os.Hostname()
+	key, legacy, err := stateLocation(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get hostname: %v", err)
+		return nil, err
+	}
+
+	keys := []string{key}
+	if legacy != "" {
+		keys = append(keys, legacy)
 	}
 
-	resp, err := m.client.Get(ctx, fmt.Sprintf("%s/%s", ETCD_CONFIG_PREFIX, hostname))
+	for _, key := range keys {
+		resp, err := m.client.Get(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get etcd state: %v", err)
+		}
+
+		if len(resp.Kvs) == 0 {
+			continue
+		}
+
+		cfg := new(traffikey.Config)
+		err = json.Unmarshal(resp.Kvs[0].Value, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal previous config: %v", err)
+		}
+
+		return cfg, nil
+	}
+
+	return nil, nil
+}
+
+// SaveState replaces the state and keeps it as a new revision
+func (m *EtcdKeymateManager) SaveState(ctx context.Context, cfg *traffikey.Config) error {
+	return m.saveRevision(ctx, cfg)
+}
+
+// WatchState sends the new state every time it is saved, until the context is cancelled
+func (m *EtcdKeymateManager) WatchState(ctx context.Context) (<-chan *traffikey.Config, error) {
+	key, _, err := stateLocation(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get etcd state: %v", err)
+		return nil, err
+	}
+
+	states := make(chan *traffikey.Config)
+	wch := m.client.Watch(ctx, key)
+
+	go func() {
+		defer close(states)
+
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				log.Warnf("error while watching state: %v", err)
+				continue
+			}
+
+			for _, ev := range resp.Events {
+				if ev.Type != etcd.EventTypePut {
+					continue
+				}
+
+				cfg := new(traffikey.Config)
+				err = json.Unmarshal(ev.Kv.Value, cfg)
+				if err != nil {
+					log.Warnf("failed to unmarshal watched state: %v", err)
+					continue
+				}
+
+				select {
+				case states <- cfg:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return states, nil
+}
+
+// Campaign takes part in the named election until the context is cancelled.
+// true is sent on the returned channel when this instance becomes the leader
+// and false when it loses the leadership because its lease expired.
+func (m *EtcdKeymateManager) Campaign(ctx context.Context, election string, identity string, ttl int) (<-chan bool, error) {
+	leadership := make(chan bool)
+
+	go func() {
+		defer close(leadership)
+
+		for ctx.Err() == nil {
+			session, err := concurrency.NewSession(m.client, concurrency.WithTTL(ttl), concurrency.WithContext(ctx))
+			if err != nil {
+				log.Warnf("failed to create election session: %v", err)
+
+				select {
+				case <-time.After(time.Duration(ttl) * time.Second):
+				case <-ctx.Done():
+				}
+				continue
+			}
+
+			e := concurrency.NewElection(session, fmt.Sprintf("%s/%s", ETCD_ELECTION_PREFIX, election))
+
+			// Blocks until we are elected
+			err = e.Campaign(ctx, identity)
+			if err != nil {
+				session.Close()
+				if ctx.Err() == nil {
+					log.Warnf("failed to campaign for election %s: %v", election, err)
+				}
+				continue
+			}
+
+			leadership <- true
+
+			select {
+			case <-ctx.Done():
+				// Give up the leadership right away so that another instance can take over
+				resignCtx, cancel := context.WithTimeout(context.Background(), time.Duration(ttl)*time.Second)
+				e.Resign(resignCtx)
+				cancel()
+
+			case <-session.Done():
+				log.Warnf("lost leadership of election %s", election)
+			}
+
+			session.Close()
+			leadership <- false
+		}
+	}()
+
+	return leadership, nil
+}
+
+// Leader returns the identity of the current leader of the election
+func (m *EtcdKeymateManager) Leader(ctx context.Context, election string) (string, error) {
+	resp, err := m.client.Get(ctx, fmt.Sprintf("%s/%s/", ETCD_ELECTION_PREFIX, election), etcd.WithFirstCreate()...)
+	if err != nil {
+		return "", fmt.Errorf("failed to get election leader: %v", err)
+	}
+
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+
+	return string(resp.Kvs[0].Value), nil
+}
+
+// GetHistory returns the probe history of the monitored target with the given key
+func (m *EtcdKeymateManager) GetHistory(ctx context.Context, key string) (*traffikey.TargetHistory, error) {
+	resp, err := m.client.Get(ctx, fmt.Sprintf("%s/%s", ETCD_HISTORY_PREFIX, key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %v", err)
 	}
 
 	if len(resp.Kvs) == 0 {
 		return nil, nil
 	}
 
-	cfg := new(traffikey.Config)
-	err = json.Unmarshal(resp.Kvs[0].Value, cfg)
+	history := new(traffikey.TargetHistory)
+	err = json.Unmarshal(resp.Kvs[0].Value, history)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal previous config: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal history: %v", err)
 	}
 
-	return cfg, nil
+	return history, nil
 }
 
-func (m *EtcdKeymateManager) SaveState(ctx context.Context, cfg *traffikey.Config) error {
-	hostname, err := os.Hostname()
+func (m *EtcdKeymateManager) ListHistories(ctx context.Context) ([]*traffikey.TargetHistory, error) {
+	resp, err := m.client.Get(ctx, ETCD_HISTORY_PREFIX+"/", etcd.WithPrefix())
 	if err != nil {
-		return fmt.Errorf("failed to get hostname: %v", err)
+		return nil, fmt.Errorf("failed to get histories: %v", err)
+	}
+
+	var histories []*traffikey.TargetHistory
+	for _, kv := range resp.Kvs {
+		history := new(traffikey.TargetHistory)
+		err = json.Unmarshal(kv.Value, history)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal history %s: %v", kv.Key, err)
+		}
+
+		histories = append(histories, history)
 	}
 
-	j, err := json.Marshal(cfg)
+	return histories, nil
+}
+
+func (m *EtcdKeymateManager) SaveHistory(ctx context.Context, history *traffikey.TargetHistory) error {
+	j, err := json.Marshal(history)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %v", err)
+		return fmt.Errorf("failed to marshal history: %v", err)
 	}
 
-	_, err = m.client.Put(ctx, fmt.Sprintf("%s/%s", ETCD_CONFIG_PREFIX, hostname), string(j))
+	_, err = m.client.Put(ctx, fmt.Sprintf("%s/%s", ETCD_HISTORY_PREFIX, history.Key), string(j))
 	if err != nil {
-		return fmt.Errorf("failed to save etcd state: %v", err)
+		return fmt.Errorf("failed to save history: %v", err)
 	}
 
 	return nil
 }
+
+// SetMaintenance puts the target with the given key (prefix/type/name) in or out of maintenance
+func (m *EtcdKeymateManager) SetMaintenance(ctx context.Context, key string, enabled bool) error {
+	maintenanceKey := fmt.Sprintf("%s/%s", ETCD_MAINTENANCE_PREFIX, key)
+
+	var err error
+	if enabled {
+		_, err = m.client.Put(ctx, maintenanceKey, time.Now().UTC().Format(time.RFC3339))
+	} else {
+		_, err = m.client.Delete(ctx, maintenanceKey)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set maintenance of target %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// ListMaintenance returns the keys of the targets in maintenance and since when
+func (m *EtcdKeymateManager) ListMaintenance(ctx context.Context) (map[string]time.Time, error) {
+	resp, err := m.client.Get(ctx, ETCD_MAINTENANCE_PREFIX+"/", etcd.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get targets in maintenance: %v", err)
+	}
+
+	maintenance := make(map[string]time.Time)
+	for _, kv := range resp.Kvs {
+		since, _ := time.Parse(time.RFC3339, string(kv.Value))
+		maintenance[strings.TrimPrefix(string(kv.Key), ETCD_MAINTENANCE_PREFIX+"/")] = since
+	}
+
+	return maintenance, nil
+}
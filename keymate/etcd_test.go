@@ -0,0 +1,118 @@
+package keymate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/numkem/traffikey"
+)
+
+// writeCertificate writes a self signed certificate and its key in PEM
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "etcd"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func TestEtcdClientConfig(t *testing.T) {
+	cfg := new(traffikey.Config)
+	cfg.SetDefaults()
+	cfg.Etcd.Endpoints = []string{"https://etcd:2379"}
+	cfg.Etcd.Username = "traffikey"
+	cfg.Etcd.Password = "secret"
+	cfg.Etcd.KeepAliveTime = traffikey.Duration(30 * time.Second)
+	cfg.Etcd.AutoSyncInterval = traffikey.Duration(time.Minute)
+
+	clientConfig, err := etcdClientConfig(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, clientConfig.TLS, "no TLS unless asked")
+	assert.Equal(t, "traffikey", clientConfig.Username)
+	assert.Equal(t, ETCD_DEFAULT_DIAL_TIMEOUT, clientConfig.DialTimeout)
+	assert.Equal(t, 30*time.Second, clientConfig.DialKeepAliveTime)
+	assert.Equal(t, time.Minute, clientConfig.AutoSyncInterval)
+
+	certFile, keyFile := writeCertificate(t, t.TempDir())
+	cfg.Etcd.CACert = certFile
+	cfg.Etcd.Cert = certFile
+	cfg.Etcd.Key = keyFile
+	cfg.Etcd.ServerName = "etcd.internal"
+
+	clientConfig, err = etcdClientConfig(cfg)
+	require.NoError(t, err)
+	require.NotNil(t, clientConfig.TLS)
+	assert.Equal(t, "etcd.internal", clientConfig.TLS.ServerName)
+	assert.NotNil(t, clientConfig.TLS.RootCAs)
+	assert.Len(t, clientConfig.TLS.Certificates, 1)
+
+	cfg.Etcd.CACert = keyFile
+	_, err = etcdClientConfig(cfg)
+	assert.ErrorContains(t, err, "no certificate found")
+}
+
+func TestUnusedMiddlewares(t *testing.T) {
+	routers := map[string]string{
+		"traefik/http/routers/web/rule":                         "Host(`web`)",
+		"traefik/http/routers/web/middlewares":                  "auth,compress",
+		"traefik/http/routers/api/middlewares":                  "auth",
+		"traefik/http/routers/tls/middlewares/not-a-router-key": "ratelimit",
+	}
+
+	assert.Equal(t, []string{"ratelimit", "headers"}, unusedMiddlewares(routers, "traefik/http/routers/", []string{"auth", "ratelimit", "compress", "headers"}))
+	delete(routers, "traefik/http/routers/web/middlewares")
+	assert.Equal(t, []string{"compress"}, unusedMiddlewares(routers, "traefik/http/routers/", []string{"auth", "compress"}), "middlewares shared with other routers are kept")
+}
+
+func TestStateKeys(t *testing.T) {
+	assert.Equal(t, "traefik/config/state/web1", stateKey("web1"))
+	assert.Equal(t, "traefik/config/web1", legacyStateKey("web1"), "state saved by previous versions")
+
+	for _, hostname := range []string{"election", "history", "locks", "maintenance", "revisions", "state"} {
+		assert.Empty(t, legacyStateKey(hostname), "%s is one of traffikey's keys", hostname)
+		assert.Equal(t, "traefik/config/state/"+hostname, stateKey(hostname))
+	}
+
+	// The state of the API is apart from the one of the host
+	ctx := WithStateName(context.Background(), API_STATE)
+	key, legacy, err := stateLocation(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "traefik/config/state/_api", key)
+	assert.Empty(t, legacy)
+	prefix, err := revisionsPrefix(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "traefik/config/revisions/_api/", prefix)
+
+	key, _, err = stateLocation(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, "traefik/config/state/_api", key)
+}
@@ -0,0 +1,212 @@
+package keymate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	etcd "go.etcd.io/etcd/client/v3"
+)
+
+// Sections of traefik's dynamic configuration
+var dynamicSections = []string{"http", "tcp", "udp", "tls"}
+
+// Names of traefik's dynamic configuration as written in its documentation.
+// The keys of the store are case insensitive and usually lower case.
+var dynamicNames = map[string]string{
+	"addprefix":             "addPrefix",
+	"basicauth":             "basicAuth",
+	"certresolver":          "certResolver",
+	"chain":                 "chain",
+	"circuitbreaker":        "circuitBreaker",
+	"compress":              "compress",
+	"customrequestheaders":  "customRequestHeaders",
+	"customresponseheaders": "customResponseHeaders",
+	"defaultcertificate":    "defaultCertificate",
+	"digestauth":            "digestAuth",
+	"entrypoints":           "entryPoints",
+	"forwardauth":           "forwardAuth",
+	"headers":               "headers",
+	"healthcheck":           "healthCheck",
+	"insecureskipverify":    "insecureSkipVerify",
+	"ipallowlist":           "ipAllowList",
+	"ipwhitelist":           "ipWhiteList",
+	"loadbalancer":          "loadBalancer",
+	"main":                  "main",
+	"middlewares":           "middlewares",
+	"minversion":            "minVersion",
+	"passhostheader":        "passHostHeader",
+	"ratelimit":             "rateLimit",
+	"redirectregex":         "redirectRegex",
+	"redirectscheme":        "redirectScheme",
+	"replacepath":           "replacePath",
+	"replacepathregex":      "replacePathRegex",
+	"responseforwarding":    "responseForwarding",
+	"rootcas":               "rootCAs",
+	"routers":               "routers",
+	"serverstransport":      "serversTransport",
+	"serverstransports":     "serversTransports",
+	"servername":            "serverName",
+	"services":              "services",
+	"sourcerange":           "sourceRange",
+	"sticky":                "sticky",
+	"stripprefix":           "stripPrefix",
+	"stripprefixregex":      "stripPrefixRegex",
+	"weighted":              "weighted",
+}
+
+// Fields of the routers holding a list that can be written as a comma separated value
+var routerListFields = map[string]bool{"entryPoints": true, "middlewares": true}
+
+// dynamicName returns the name of the key as written in traefik's documentation
+func dynamicName(segment string) string {
+	if name, ok := dynamicNames[strings.ToLower(segment)]; ok {
+		return name
+	}
+
+	return segment
+}
+
+// dynamicValue converts the values of the store to booleans and numbers when they are
+func dynamicValue(value string) interface{} {
+	if value == "true" || value == "false" {
+		return value == "true"
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(i, 10) == value {
+		return i
+	}
+
+	return value
+}
+
+// normalizeNode turns the nodes with only indexes as keys into lists and
+// converts the values. The path holds the names of the node and its parents,
+// the elements of lists are named with an empty string.
+func normalizeNode(path []string, node interface{}) interface{} {
+	value, ok := node.(string)
+	if ok {
+		isRouterField := len(path) == 4 && path[1] == "routers"
+		if isRouterField && routerListFields[path[3]] {
+			var list []interface{}
+			for _, s := range strings.Split(value, ",") {
+				list = append(list, strings.TrimSpace(s))
+			}
+			return list
+		}
+
+		// tls = true enables TLS with the default options
+		if isRouterField && path[3] == "tls" && value == "true" {
+			return map[string]interface{}{}
+		}
+
+		return dynamicValue(value)
+	}
+
+	children := node.(map[string]interface{})
+
+	indexes := make([]int, 0, len(children))
+	for key := range children {
+		index, err := strconv.Atoi(key)
+		if err != nil {
+			indexes = nil
+			break
+		}
+		indexes = append(indexes, index)
+	}
+
+	if len(indexes) > 0 {
+		sort.Ints(indexes)
+
+		list := make([]interface{}, 0, len(indexes))
+		for _, index := range indexes {
+			list = append(list, normalizeNode(append(path[:len(path):len(path)], ""), children[strconv.Itoa(index)]))
+		}
+		return list
+	}
+
+	normalized := make(map[string]interface{}, len(children))
+	for key, child := range children {
+		name := key
+		// Names of routers, services and middlewares are kept as they are
+		if len(path) != 2 {
+			name = dynamicName(key)
+		}
+
+		normalized[name] = normalizeNode(append(path[:len(path):len(path)], name), child)
+	}
+
+	return normalized
+}
+
+// dynamicConfig builds traefik's dynamic configuration from the keys of the prefix
+func dynamicConfig(prefix string, kvs etcdKeyValue) map[string]interface{} {
+	keys := make([]string, 0, len(kvs))
+	for key := range kvs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	root := make(map[string]interface{})
+	for _, key := range keys {
+		segments := strings.Split(strings.TrimPrefix(key, prefix+"/"), "/")
+
+		node := root
+		for _, segment := range segments[:len(segments)-1] {
+			child, ok := node[segment].(map[string]interface{})
+			if !ok {
+				// A value with children, like the tls key of a router, is replaced by them
+				child = make(map[string]interface{})
+				node[segment] = child
+			}
+			node = child
+		}
+
+		last := segments[len(segments)-1]
+		if _, ok := node[last].(map[string]interface{}); !ok {
+			node[last] = kvs[key]
+		}
+	}
+
+	cfg := make(map[string]interface{})
+	for section, node := range root {
+		name := strings.ToLower(section)
+		if !slices.Contains(dynamicSections, name) {
+			log.Warnf("keys under %s/%s aren't part of traefik's dynamic configuration, skipping them", prefix, section)
+			continue
+		}
+
+		if _, ok := node.(map[string]interface{}); !ok {
+			log.Warnf("key %s/%s has no children, skipping it", prefix, section)
+			continue
+		}
+
+		cfg[name] = normalizeNode([]string{name}, node)
+	}
+
+	return cfg
+}
+
+// Export returns traefik's dynamic configuration written under the prefix,
+// including the keys that traffikey doesn't manage
+func (m *EtcdKeymateManager) Export(ctx context.Context, prefix string) (map[string]interface{}, error) {
+	resp, err := m.client.Get(ctx, prefix+"/", etcd.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys of prefix %s: %v", prefix, err)
+	}
+
+	kvs := make(etcdKeyValue)
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		if strings.HasPrefix(key, ETCD_CONFIG_PREFIX+"/") {
+			continue
+		}
+
+		kvs[key] = string(kv.Value)
+	}
+
+	return dynamicConfig(prefix, kvs), nil
+}
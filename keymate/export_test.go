@@ -0,0 +1,77 @@
+package keymate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDynamicConfig(t *testing.T) {
+	kvs := etcdKeyValue{
+		"traefik/http/routers/Web/entrypoints":                    "web,websecure",
+		"traefik/http/routers/Web/rule":                           "Host(`web`)",
+		"traefik/http/routers/Web/priority":                       "10",
+		"traefik/http/routers/Web/tls":                            "true",
+		"traefik/http/routers/Web/tls/certresolver":               "le",
+		"traefik/http/routers/Web/middlewares/0":                  "auth",
+		"traefik/http/routers/api/tls":                            "true",
+		"traefik/http/services/Web/loadbalancer/servers/0/url":    "http://10.0.0.1",
+		"traefik/http/services/Web/loadbalancer/servers/1/url":    "http://10.0.0.2",
+		"traefik/http/services/Web/loadbalancer/passhostheader":   "false",
+		"traefik/http/middlewares/auth/basicauth/users/0":         "a:b",
+		"traefik/http/serverstransports/skip/insecureskipverify":  "true",
+		"traefik/tcp/services/ssh/loadbalancer/servers/0/address": "10.0.0.3:22",
+		"traefik/tls/options/modern/minversion":                   "VersionTLS13",
+		"traefik/other/key":                                       "ignored",
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"http": map[string]interface{}{
+			"routers": map[string]interface{}{
+				"Web": map[string]interface{}{
+					"entryPoints": []interface{}{"web", "websecure"},
+					"rule":        "Host(`web`)",
+					"priority":    int64(10),
+					"tls":         map[string]interface{}{"certResolver": "le"},
+					"middlewares": []interface{}{"auth"},
+				},
+				"api": map[string]interface{}{
+					"tls": map[string]interface{}{},
+				},
+			},
+			"services": map[string]interface{}{
+				"Web": map[string]interface{}{
+					"loadBalancer": map[string]interface{}{
+						"servers": []interface{}{
+							map[string]interface{}{"url": "http://10.0.0.1"},
+							map[string]interface{}{"url": "http://10.0.0.2"},
+						},
+						"passHostHeader": false,
+					},
+				},
+			},
+			"middlewares": map[string]interface{}{
+				"auth": map[string]interface{}{
+					"basicAuth": map[string]interface{}{"users": []interface{}{"a:b"}},
+				},
+			},
+			"serversTransports": map[string]interface{}{
+				"skip": map[string]interface{}{"insecureSkipVerify": true},
+			},
+		},
+		"tcp": map[string]interface{}{
+			"services": map[string]interface{}{
+				"ssh": map[string]interface{}{
+					"loadBalancer": map[string]interface{}{
+						"servers": []interface{}{map[string]interface{}{"address": "10.0.0.3:22"}},
+					},
+				},
+			},
+		},
+		"tls": map[string]interface{}{
+			"options": map[string]interface{}{
+				"modern": map[string]interface{}{"minVersion": "VersionTLS13"},
+			},
+		},
+	}, dynamicConfig("traefik", kvs))
+}
@@ -0,0 +1,220 @@
+package keymate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/numkem/traffikey"
+)
+
+// Import is the result of reading the targets from the keys of a prefix
+type Import struct {
+	Targets []*traffikey.Target `json:"targets"`
+	// Keys of the prefix that applying the targets wouldn't write back
+	Unsupported []string `json:"unsupported"`
+	// Keys that applying the targets would write with another value
+	Changed []*KeyChange `json:"changed"`
+	// Targets that can't be applied as they are
+	Problems []string `json:"problems"`
+}
+
+// indexedValues returns the values of the keys <base>/<index> ordered by index
+func indexedValues(live etcdKeyValue, base string) []string {
+	type indexed struct {
+		index int
+		value string
+	}
+
+	var values []indexed
+	for key, value := range live {
+		rest, ok := strings.CutPrefix(key, base+"/")
+		if !ok {
+			continue
+		}
+
+		index, err := strconv.Atoi(rest)
+		if err != nil {
+			continue
+		}
+		values = append(values, indexed{index, value})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].index < values[j].index })
+
+	var ss []string
+	for _, v := range values {
+		ss = append(ss, v.value)
+	}
+
+	return ss
+}
+
+// listValue reads a list written either as a comma separated value or one key per index
+func listValue(live etcdKeyValue, key string) []string {
+	if value, ok := live[key]; ok {
+		return strings.Split(value, ",")
+	}
+
+	return indexedValues(live, key)
+}
+
+// serverURLs returns the servers of the load balancer of the service ordered by index
+func serverURLs(live etcdKeyValue, servicePrefix string) []string {
+	type server struct {
+		index int
+		url   string
+	}
+
+	var servers []server
+	for key, value := range live {
+		rest, ok := strings.CutPrefix(key, servicePrefix+"loadbalancer/servers/")
+		if !ok {
+			continue
+		}
+
+		id, suffix, _ := strings.Cut(rest, "/")
+		index, err := strconv.Atoi(id)
+		if err != nil || (suffix != "url" && suffix != "address") {
+			continue
+		}
+		servers = append(servers, server{index, value})
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].index < servers[j].index })
+
+	urls := []string{}
+	for _, s := range servers {
+		urls = append(urls, s.url)
+	}
+
+	return urls
+}
+
+// middlewareFromKeys reads the middleware, only its first kind is kept
+func middlewareFromKeys(live etcdKeyValue, middlewarePrefix string, name string) *traffikey.Middleware {
+	var keys []string
+	for key := range live {
+		if strings.HasPrefix(key, middlewarePrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	middleware := &traffikey.Middleware{Name: name, Values: make(map[string]string)}
+	for _, key := range keys {
+		kind, rest, ok := strings.Cut(strings.TrimPrefix(key, middlewarePrefix), "/")
+		if !ok {
+			continue
+		}
+
+		if middleware.Kind == "" {
+			middleware.Kind = kind
+		}
+		if kind == middleware.Kind {
+			middleware.Values[rest] = live[key]
+		}
+	}
+
+	return middleware
+}
+
+// importKeys rebuilds the targets from the routers found in the keys of the
+// prefix and compares what they would write to the keys
+func importKeys(prefix string, live etcdKeyValue) *Import {
+	imp := &Import{Targets: []*traffikey.Target{}}
+
+	var routers []string
+	seen := make(map[string]bool)
+	for key := range live {
+		// <type>/routers/<name>/...
+		ss := strings.SplitN(strings.TrimPrefix(key, prefix+"/"), "/", 4)
+		if len(ss) < 4 || ss[1] != "routers" || seen[ss[0]+"/"+ss[2]] {
+			continue
+		}
+
+		seen[ss[0]+"/"+ss[2]] = true
+		routers = append(routers, ss[0]+"/"+ss[2])
+	}
+	sort.Strings(routers)
+
+	for _, router := range routers {
+		routerType, name, _ := strings.Cut(router, "/")
+		routerPrefix := fmt.Sprintf("%s/%s/routers/%s/", prefix, routerType, name)
+
+		target := &traffikey.Target{
+			Name:         name,
+			Type:         routerType,
+			Prefix:       prefix,
+			Rule:         live[routerPrefix+"rule"],
+			Entrypoint:   strings.Join(listValue(live, routerPrefix+"entrypoints"), ","),
+			Middlewares:  []*traffikey.Middleware{},
+			TLSExtraKeys: map[string]string{},
+		}
+
+		service := name
+		if s, ok := live[routerPrefix+"service"]; ok {
+			service = s
+		}
+		if service != name {
+			imp.Problems = append(imp.Problems, fmt.Sprintf("router %s uses service %s, traffikey names the service after its router", TargetKey(target), service))
+		}
+		target.ServerURLs = serverURLs(live, fmt.Sprintf("%s/%s/services/%s/", prefix, routerType, service))
+
+		if _, ok := live[routerPrefix+"tls"]; ok {
+			target.TLS = true
+		}
+		for key, value := range live {
+			if extra, ok := strings.CutPrefix(key, routerPrefix+"tls/"); ok {
+				target.TLS = true
+				target.TLSExtraKeys[extra] = value
+			}
+		}
+
+		for _, mw := range listValue(live, routerPrefix+"middlewares") {
+			target.Middlewares = append(target.Middlewares, middlewareFromKeys(live, fmt.Sprintf("%s/%s/middlewares/%s/", prefix, routerType, mw), mw))
+		}
+
+		if target.Rule == "" {
+			imp.Problems = append(imp.Problems, fmt.Sprintf("router %s has no rule, traffikey requires one", TargetKey(target)))
+		}
+
+		imp.Targets = append(imp.Targets, target)
+	}
+
+	plan := diffKeys(imp.Targets, nil, live)
+	imp.Changed = plan.Puts
+	imp.Unsupported = plan.Foreign
+	for _, c := range plan.Deletes {
+		imp.Unsupported = append(imp.Unsupported, c.Key)
+	}
+	sort.Strings(imp.Unsupported)
+
+	return imp
+}
+
+// ImportTargets reads the targets from the keys under the prefix. The keys
+// attached to a lease, like the ones of agents, are left out.
+func (m *EtcdKeymateManager) ImportTargets(ctx context.Context, prefix string) (*Import, error) {
+	live, err := m.liveKeys(ctx, []string{prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	return escapeImport(importKeys(prefix, live))
+}
+
+// escapeImport escapes the ${ of the values of the targets, like the ones of
+// traefik's regex replacements, so that applying them doesn't interpolate them
+func escapeImport(imp *Import) (*Import, error) {
+	for i, target := range imp.Targets {
+		escaped, err := traffikey.EscapeTarget(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to escape target %s: %v", TargetKey(target), err)
+		}
+
+		imp.Targets[i] = escaped
+	}
+
+	return imp, nil
+}
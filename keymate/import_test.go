@@ -0,0 +1,94 @@
+package keymate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/maps"
+
+	"github.com/numkem/traffikey"
+)
+
+func TestImportKeys(t *testing.T) {
+	web := &traffikey.Target{
+		Name: "web", Type: "http", Prefix: "traefik", Entrypoint: "https", Rule: "Host(`web`)",
+		ServerURLs:   []string{"http://10.0.0.1", "http://10.0.0.2"},
+		TLS:          true,
+		TLSExtraKeys: map[string]string{"certresolver": "le"},
+		Middlewares: []*traffikey.Middleware{
+			{Name: "auth", Kind: "basicauth", Values: map[string]string{"users/0": "a:b"}},
+		},
+	}
+	ssh := &traffikey.Target{
+		Name: "ssh", Type: "tcp", Prefix: "traefik", Entrypoint: "ssh", Rule: "HostSNI(`*`)",
+		ServerURLs:   []string{"10.0.0.3:22"},
+		Middlewares:  []*traffikey.Middleware{},
+		TLSExtraKeys: map[string]string{},
+	}
+
+	live := keysForTarget(web)
+	maps.Copy(live, keysForTarget(ssh))
+
+	imp := importKeys("traefik", live)
+	assert.Empty(t, imp.Unsupported)
+	assert.Empty(t, imp.Changed)
+	assert.Empty(t, imp.Problems)
+	require.Len(t, imp.Targets, 2)
+	assert.Equal(t, web, imp.Targets[0])
+	assert.Equal(t, ssh, imp.Targets[1])
+
+	// Keys written by traefik's own conventions or other tools
+	live = etcdKeyValue{
+		"traefik/http/routers/api/rule":                            "Host(`api`)",
+		"traefik/http/routers/api/entrypoints/0":                   "https",
+		"traefik/http/routers/api/priority":                        "10",
+		"traefik/http/routers/api/service":                         "backend",
+		"traefik/http/services/backend/loadbalancer/servers/0/url": "http://10.0.0.4",
+		"traefik/udp/routers/dns/service":                          "dns",
+		"traefik/udp/services/dns/loadbalancer/servers/0/address":  "10.0.0.5:53",
+	}
+
+	imp = importKeys("traefik", live)
+	require.Len(t, imp.Targets, 2)
+	assert.Equal(t, "https", imp.Targets[0].Entrypoint)
+	assert.Equal(t, []string{"http://10.0.0.4"}, imp.Targets[0].ServerURLs)
+	assert.Len(t, imp.Problems, 2, "service named differently and missing rule")
+	assert.Equal(t, []string{
+		"traefik/http/routers/api/entrypoints/0",
+		"traefik/http/routers/api/priority",
+		"traefik/http/services/backend/loadbalancer/servers/0/url",
+	}, imp.Unsupported)
+}
+
+func TestImportThenPlan(t *testing.T) {
+	t.Setenv("TRAFFIKEY_TEST_HOME", "/home/traffikey")
+
+	live := etcdKeyValue{
+		"traefik/http/routers/web/rule":                                 "Host(`web`)",
+		"traefik/http/routers/web/entrypoints":                          "https",
+		"traefik/http/routers/web/service":                              "web",
+		"traefik/http/routers/web/middlewares":                          "rewrite",
+		"traefik/http/services/web/loadbalancer/servers/0/url":          "http://10.0.0.1",
+		"traefik/http/middlewares/rewrite/replacepathregex/regex":       "^/(.*)",
+		"traefik/http/middlewares/rewrite/replacepathregex/replacement": "${TRAFFIKEY_TEST_HOME}/${1}/$${literal}",
+	}
+
+	imp, err := escapeImport(importKeys("traefik", live))
+	require.NoError(t, err)
+	require.Empty(t, imp.Changed)
+
+	b, err := traffikey.MarshalYAML(&traffikey.Config{Targets: imp.Targets})
+	require.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "traffikey.yaml")
+	require.NoError(t, os.WriteFile(filename, b, 0o644))
+
+	cfg, err := traffikey.NewConfig(filename)
+	require.NoError(t, err)
+
+	plan := diffKeys(cfg.Targets, nil, live)
+	assert.Empty(t, plan.Puts, "imported values shouldn't be interpolated")
+	assert.Empty(t, plan.Deletes)
+}
@@ -2,16 +2,51 @@ package keymate
 
 import (
 	"context"
+	"errors"
+	"io"
+	"time"
 
 	"github.com/numkem/traffikey"
 )
 
+// ErrInvalidTarget is returned when a target's configuration is invalid
+var ErrInvalidTarget = errors.New("invalid target")
+
 type KeymateConnector interface {
 	ApplyConfig(ctx context.Context, cfg *traffikey.Config) []error
+	ApplyTarget(ctx context.Context, target *traffikey.Target) error
+	DeleteTarget(ctx context.Context, target *traffikey.Target) error
 	ListTargets(ctx context.Context, cfg *traffikey.Config) ([]*traffikey.Target, error)
 	ListTargetsByOwner(ctx context.Context, owner string) ([]*traffikey.Target, error)
 	DeleteTargetByName(ctx context.Context, target string, prefix string) error
+	RegisterTargets(ctx context.Context, targets []*traffikey.Target, ttl int) (<-chan bool, error)
+
+	Plan(ctx context.Context, cfg *traffikey.Config, state *traffikey.Config) (*Plan, error)
+	ApplyPlan(ctx context.Context, plan *Plan) error
+	Lock(ctx context.Context, prefixes []string) (func(), error)
+	LockHolder(ctx context.Context, name string) (string, error)
+	ForceUnlock(ctx context.Context, name string) error
+	WatchPrefixes(ctx context.Context, prefixes []string) (<-chan string, error)
+	ImportTargets(ctx context.Context, prefix string) (*Import, error)
+	Export(ctx context.Context, prefix string) (map[string]interface{}, error)
+	Backup(ctx context.Context, prefixes []string, w io.Writer) (*BackupHeader, error)
+	PlanRestore(ctx context.Context, header *BackupHeader, entries []*BackupEntry, replace bool) (*Plan, error)
+	PlanPrefixCopy(ctx context.Context, c *PrefixCopy) (*PrefixPlan, error)
+	ApplyPrefixCopy(ctx context.Context, plan *PrefixPlan) error
 
 	GetState(ctx context.Context) (*traffikey.Config, error)
 	SaveState(ctx context.Context, cfg *traffikey.Config) error
+	WatchState(ctx context.Context) (<-chan *traffikey.Config, error)
+	ListRevisions(ctx context.Context) ([]*traffikey.Revision, error)
+	GetRevision(ctx context.Context, number int) (*traffikey.Revision, error)
+
+	GetHistory(ctx context.Context, key string) (*traffikey.TargetHistory, error)
+	ListHistories(ctx context.Context) ([]*traffikey.TargetHistory, error)
+	SaveHistory(ctx context.Context, history *traffikey.TargetHistory) error
+
+	SetMaintenance(ctx context.Context, key string, enabled bool) error
+	ListMaintenance(ctx context.Context) (map[string]time.Time, error)
+
+	Campaign(ctx context.Context, election string, identity string, ttl int) (<-chan bool, error)
+	Leader(ctx context.Context, election string) (string, error)
 }
@@ -0,0 +1,147 @@
+package keymate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	etcd "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const ETCD_LOCK_PREFIX = ETCD_CONFIG_PREFIX + "/locks"
+
+// Time to wait for the locks when the configuration doesn't set it
+const DEFAULT_LOCK_TIMEOUT = 30 * time.Second
+
+// Seconds before the locks of a process that died are released
+const LOCK_SESSION_TTL = 10
+
+// ErrLocked is returned when a lock couldn't be taken before the timeout
+var ErrLocked = errors.New("store is locked")
+
+// LockNames returns the names of the locks protecting the state of this host
+// and the prefixes, in the order they are taken
+func LockNames(prefixes []string) ([]string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %v", err)
+	}
+
+	var names []string
+	for _, prefix := range prefixes {
+		name := "prefixes/" + prefix
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	// The state is rewritten as a whole, its lock comes first
+	return append([]string{"owners/" + hostname}, names...), nil
+}
+
+func lockKey(name string) string {
+	return fmt.Sprintf("%s/%s", ETCD_LOCK_PREFIX, name)
+}
+
+// Lock takes the locks of the state and of the prefixes. When they are held,
+// it waits for them up to the timeout of the configuration. The returned
+// function releases them.
+func (m *EtcdKeymateManager) Lock(ctx context.Context, prefixes []string) (func(), error) {
+	names, err := LockNames(prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(m.cfg.Etcd.LockTimeout)
+	if timeout == 0 {
+		timeout = DEFAULT_LOCK_TIMEOUT
+	}
+
+	session, err := concurrency.NewSession(m.client, concurrency.WithTTL(LOCK_SESSION_TTL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock session: %v", err)
+	}
+
+	var mutexes []*concurrency.Mutex
+	unlock := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(LOCK_SESSION_TTL)*time.Second)
+		defer cancel()
+
+		for i := len(mutexes) - 1; i >= 0; i-- {
+			err := mutexes[i].Unlock(ctx)
+			if err != nil {
+				log.Warnf("failed to release lock: %v", err)
+			}
+		}
+
+		session.Close()
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, name := range names {
+		mutex := concurrency.NewMutex(session, lockKey(name))
+
+		err := mutex.TryLock(lockCtx)
+		if errors.Is(err, concurrency.ErrLocked) {
+			holder, _ := m.LockHolder(ctx, name)
+			log.Warnf("waiting for lock %s held by %s", name, holder)
+
+			err = mutex.Lock(lockCtx)
+		}
+		if err != nil {
+			unlock()
+
+			if errors.Is(lockCtx.Err(), context.DeadlineExceeded) {
+				holder, _ := m.LockHolder(ctx, name)
+				return nil, fmt.Errorf("%w: lock %s is still held by %s after %s", ErrLocked, name, holder, timeout)
+			}
+			return nil, fmt.Errorf("failed to take lock %s: %v", name, err)
+		}
+		mutexes = append(mutexes, mutex)
+
+		// The key of the mutex tells who holds it
+		_, err = m.client.Put(ctx, mutex.Key(), authorOf(ctx), etcd.WithLease(session.Lease()))
+		if err != nil {
+			log.Warnf("failed to write the holder of lock %s: %v", name, err)
+		}
+	}
+
+	return unlock, nil
+}
+
+// LockHolder returns who holds the lock, empty when it is free
+func (m *EtcdKeymateManager) LockHolder(ctx context.Context, name string) (string, error) {
+	resp, err := m.client.Get(ctx, lockKey(name)+"/", etcd.WithFirstCreate()...)
+	if err != nil {
+		return "", fmt.Errorf("failed to get holder of lock %s: %v", name, err)
+	}
+
+	if len(resp.Kvs) == 0 {
+		return "", nil
+	}
+
+	holder := string(resp.Kvs[0].Value)
+	if holder == "" {
+		holder = "unknown"
+	}
+
+	return holder, nil
+}
+
+// ForceUnlock releases the lock whoever holds it
+func (m *EtcdKeymateManager) ForceUnlock(ctx context.Context, name string) error {
+	_, err := m.client.Delete(ctx, lockKey(name)+"/", etcd.WithPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to release lock %s: %v", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+package keymate
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockNames(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	names, err := LockNames([]string{"traefik", "other", "traefik"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"owners/" + hostname, "prefixes/other", "prefixes/traefik"}, names, "the state comes first, then the prefixes once in order")
+}
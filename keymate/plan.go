@@ -0,0 +1,362 @@
+package keymate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	etcd "go.etcd.io/etcd/client/v3"
+	"golang.org/x/exp/maps"
+
+	"github.com/numkem/traffikey"
+)
+
+// Maximum number of operations etcd accepts in a transaction by default
+const ETCD_MAX_TXN_OPS = 128
+
+// KeyChange is a key of the store that doesn't match the configuration
+type KeyChange struct {
+	Key string `json:"key"`
+	// Empty when the key is missing from the store
+	Current string `json:"current,omitempty"`
+	// Empty when the key should be deleted
+	Wanted string `json:"wanted,omitempty"`
+	// prefix/type/name of the target the key belongs to
+	Target string `json:"target"`
+}
+
+// Plan is what to change in the store so that it matches a configuration
+type Plan struct {
+	// Keys missing from the store or holding another value
+	Puts []*KeyChange `json:"puts"`
+	// Keys of known targets that the configuration doesn't produce
+	Deletes []*KeyChange `json:"deletes"`
+	// Keys under the prefixes that don't belong to any known target, or of the
+	// middlewares of removed targets that other routers use, they are left alone
+	Foreign []string `json:"foreign"`
+	// Targets of the state that are no longer in the configuration
+	Removed []*traffikey.Target `json:"removed"`
+}
+
+// Empty returns whether the store already matches the configuration
+func (p *Plan) Empty() bool {
+	return len(p.Puts) == 0 && len(p.Deletes) == 0
+}
+
+// Redacted returns a copy of the plan with the values passed through the
+// function, to show it without the interpolated secrets
+func (p *Plan) Redacted(redact func(string) string) *Plan {
+	redacted := *p
+	redacted.Puts = make([]*KeyChange, 0, len(p.Puts))
+	for _, c := range p.Puts {
+		redacted.Puts = append(redacted.Puts, &KeyChange{Key: c.Key, Current: redact(c.Current), Wanted: redact(c.Wanted), Target: c.Target})
+	}
+	redacted.Deletes = make([]*KeyChange, 0, len(p.Deletes))
+	for _, c := range p.Deletes {
+		redacted.Deletes = append(redacted.Deletes, &KeyChange{Key: c.Key, Current: redact(c.Current), Target: c.Target})
+	}
+
+	return &redacted
+}
+
+// TargetKey identifies a target as prefix/type/name
+func TargetKey(target *traffikey.Target) string {
+	return fmt.Sprintf("%s/%s/%s", target.Prefix, target.Type, target.Name)
+}
+
+// ownedPrefixes returns the key prefixes holding the keys of the target
+func ownedPrefixes(target *traffikey.Target) []string {
+	prefixes := []string{
+		fmt.Sprintf("%s/%s/routers/%s/", target.Prefix, target.Type, target.Name),
+		fmt.Sprintf("%s/%s/services/%s/", target.Prefix, target.Type, target.Name),
+	}
+	for _, middleware := range target.Middlewares {
+		prefixes = append(prefixes, fmt.Sprintf("%s/%s/middlewares/%s/", target.Prefix, target.Type, middleware.Name))
+	}
+
+	return prefixes
+}
+
+// diffKeys compares the live keys of the store to the ones the targets
+// produce. The keys of the removed targets are deleted, except for their
+// middlewares that other routers still reference.
+func diffKeys(targets []*traffikey.Target, removed []*traffikey.Target, live etcdKeyValue) *Plan {
+	plan := &Plan{Removed: removed}
+
+	wanted := make(etcdKeyValue)
+	owners := make(map[string]string)
+	for _, target := range targets {
+		for key, value := range keysForTarget(target) {
+			wanted[key] = value
+			owners[key] = TargetKey(target)
+		}
+	}
+
+	keys := make([]string, 0, len(wanted))
+	for key := range wanted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		current, ok := live[key]
+		if !ok || current != wanted[key] {
+			plan.Puts = append(plan.Puts, &KeyChange{Key: key, Current: current, Wanted: wanted[key], Target: owners[key]})
+		}
+	}
+
+	type owned struct {
+		prefix string
+		target string
+		// Name of the middleware the keys belong to, empty for routers and services
+		middleware string
+		// Routers that can reference the middleware
+		routers string
+	}
+	var prefixes []owned
+	for _, target := range append(append([]*traffikey.Target{}, targets...), removed...) {
+		for _, prefix := range ownedPrefixes(target) {
+			o := owned{prefix: prefix, target: TargetKey(target)}
+			if name, ok := strings.CutPrefix(prefix, fmt.Sprintf("%s/%s/middlewares/", target.Prefix, target.Type)); ok {
+				o.middleware = strings.TrimSuffix(name, "/")
+				o.routers = fmt.Sprintf("%s/%s/routers/", target.Prefix, target.Type)
+			}
+			prefixes = append(prefixes, o)
+		}
+	}
+
+	owner := func(key string) *owned {
+		for i, o := range prefixes {
+			if strings.HasPrefix(key, o.prefix) {
+				return &prefixes[i]
+			}
+		}
+
+		return nil
+	}
+
+	// Middlewares are shared by name, the ones still referenced by the routers
+	// left once the plan is applied are kept: the wanted routers and the ones
+	// traffikey doesn't manage
+	remaining := maps.Clone(wanted)
+	for key, value := range live {
+		if _, ok := wanted[key]; !ok && owner(key) == nil {
+			remaining[key] = value
+		}
+	}
+
+	keys = keys[:0]
+	for key := range live {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, ok := wanted[key]; ok {
+			continue
+		}
+
+		o := owner(key)
+		shared := o != nil && o.middleware != "" &&
+			len(unusedMiddlewares(routerKeys(remaining, o.routers), o.routers, []string{o.middleware})) == 0
+		if o == nil || shared {
+			plan.Foreign = append(plan.Foreign, key)
+		} else {
+			plan.Deletes = append(plan.Deletes, &KeyChange{Key: key, Current: live[key], Target: o.target})
+		}
+	}
+
+	return plan
+}
+
+// routerKeys returns the keys under the routers prefix
+func routerKeys(keys etcdKeyValue, routersPrefix string) map[string]string {
+	routers := make(map[string]string)
+	for key, value := range keys {
+		if strings.HasPrefix(key, routersPrefix) {
+			routers[key] = value
+		}
+	}
+
+	return routers
+}
+
+// normalizeTargets fills the defaults of the targets and validates them
+func (m *EtcdKeymateManager) normalizeTargets(cfg *traffikey.Config, targets []*traffikey.Target) error {
+	var errs []error
+	for _, target := range targets {
+		if cfg != nil && cfg.Traefik != nil {
+			if target.Prefix == "" {
+				target.Prefix = cfg.Traefik.DefaultPrefix
+			}
+			if target.Entrypoint == "" {
+				target.Entrypoint = cfg.Traefik.DefaultEntrypoint
+			}
+		}
+
+		err := m.validateTarget(target)
+		if err != nil && target.Source != "" {
+			err = fmt.Errorf("%s: %v", target.Source, err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidTarget, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// liveKeys returns the keys under the prefixes. The keys of traffikey and the
+// ones attached to a lease, like the ones of agents, are left out.
+func (m *EtcdKeymateManager) liveKeys(ctx context.Context, prefixes []string) (etcdKeyValue, error) {
+	live := make(etcdKeyValue)
+	for _, prefix := range prefixes {
+		resp, err := m.client.Get(ctx, prefix+"/", etcd.WithPrefix())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get keys of prefix %s: %v", prefix, err)
+		}
+
+		for _, kv := range resp.Kvs {
+			key := string(kv.Key)
+			if kv.Lease != 0 || strings.HasPrefix(key, ETCD_CONFIG_PREFIX+"/") {
+				continue
+			}
+
+			live[key] = string(kv.Value)
+		}
+	}
+
+	return live, nil
+}
+
+// Plan compares the store to the configuration. The targets of the previous
+// state that aren't in the configuration anymore are removed. Like
+// ApplyConfig, the defaults are filled in the targets of the configuration.
+func (m *EtcdKeymateManager) Plan(ctx context.Context, cfg *traffikey.Config, state *traffikey.Config) (*Plan, error) {
+	err := m.normalizeTargets(cfg, cfg.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, target := range cfg.Targets {
+		wanted[TargetKey(target)] = true
+	}
+
+	var removed []*traffikey.Target
+	if state != nil {
+		// The state is saved with the ${...} expressions of its secrets
+		interpolated := *state
+		err = interpolated.Interpolate()
+		if err != nil {
+			return nil, fmt.Errorf("failed to interpolate state: %v", err)
+		}
+		state = &interpolated
+
+		for _, target := range state.Targets {
+			t := *target
+			if m.normalizeTargets(state, []*traffikey.Target{&t}) != nil {
+				continue
+			}
+
+			if !wanted[TargetKey(&t)] {
+				removed = append(removed, &t)
+			}
+		}
+	}
+
+	prefixes := cfg.Prefixes()
+	for _, target := range removed {
+		if !slices.Contains(prefixes, target.Prefix) {
+			prefixes = append(prefixes, target.Prefix)
+		}
+	}
+
+	live, err := m.liveKeys(ctx, prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffKeys(cfg.Targets, removed, live), nil
+}
+
+// ApplyPlan writes the changes of the plan in as few transactions as
+// possible. etcd limits the operations of a transaction, so plans of more
+// than ETCD_MAX_TXN_OPS changes are written in several of them: when one
+// fails, the ones before it were written and the error says how many changes
+// were. Planning again gives what is left to change.
+func (m *EtcdKeymateManager) ApplyPlan(ctx context.Context, plan *Plan) error {
+	var ops []etcd.Op
+	for _, c := range plan.Puts {
+		ops = append(ops, etcd.OpPut(c.Key, c.Wanted))
+	}
+	for _, c := range plan.Deletes {
+		ops = append(ops, etcd.OpDelete(c.Key))
+	}
+
+	for i := 0; i < len(ops); i += ETCD_MAX_TXN_OPS {
+		end := min(i+ETCD_MAX_TXN_OPS, len(ops))
+
+		_, err := m.client.Txn(ctx).Then(ops[i:end]...).Commit()
+		if err != nil && i > 0 {
+			return fmt.Errorf("failed to apply changes to etcd, the store is partly changed: %d of the %d changes were written: %v", i, len(ops), err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply changes to etcd: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// WatchPrefixes sends the keys changed under the prefixes until the context is
+// cancelled. The keys of traffikey are left out.
+func (m *EtcdKeymateManager) WatchPrefixes(ctx context.Context, prefixes []string) (<-chan string, error) {
+	keys := make(chan string)
+	done := make(chan struct{})
+
+	for _, prefix := range prefixes {
+		wch := m.client.Watch(ctx, prefix+"/", etcd.WithPrefix())
+
+		go func() {
+			defer func() { done <- struct{}{} }()
+
+			for resp := range wch {
+				if err := resp.Err(); err != nil {
+					log.Warnf("error while watching keys: %v", err)
+					continue
+				}
+
+				for _, ev := range resp.Events {
+					key := string(ev.Kv.Key)
+					if strings.HasPrefix(key, ETCD_CONFIG_PREFIX+"/") {
+						continue
+					}
+
+					select {
+					case keys <- key:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for range prefixes {
+			<-done
+		}
+		close(keys)
+	}()
+
+	return keys, nil
+}
@@ -0,0 +1,73 @@
+package keymate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/numkem/traffikey"
+)
+
+func TestDiffKeys(t *testing.T) {
+	web := &traffikey.Target{Name: "web", Type: "http", Prefix: "traefik", Entrypoint: "https", Rule: "Host(`web`)", ServerURLs: []string{"http://10.0.0.1"}}
+	old := &traffikey.Target{Name: "old", Type: "http", Prefix: "traefik"}
+
+	live := etcdKeyValue{
+		"traefik/http/routers/web/entrypoints":                    "https",
+		"traefik/http/routers/web/rule":                           "Host(`other`)",
+		"traefik/http/routers/web/tls":                            "true",
+		"traefik/http/routers/old/rule":                           "Host(`old`)",
+		"traefik/http/services/old/loadbalancer/servers/0/url":    "http://10.0.0.2",
+		"traefik/http/routers/manual/rule":                        "Host(`manual`)",
+		"traefik/http/services/web/loadbalancer/servers/0/url":    "http://10.0.0.1",
+		"traefik/http/services/webapp/loadbalancer/servers/0/url": "http://10.0.0.3",
+	}
+
+	plan := diffKeys([]*traffikey.Target{web}, []*traffikey.Target{old}, live)
+	assert.False(t, plan.Empty())
+
+	require.Len(t, plan.Puts, 2)
+	assert.Equal(t, &KeyChange{Key: "traefik/http/routers/web/rule", Current: "Host(`other`)", Wanted: "Host(`web`)", Target: "traefik/http/web"}, plan.Puts[0])
+	assert.Equal(t, &KeyChange{Key: "traefik/http/routers/web/service", Wanted: "web", Target: "traefik/http/web"}, plan.Puts[1], "missing keys are restored")
+
+	var deletes []string
+	for _, c := range plan.Deletes {
+		deletes = append(deletes, c.Key)
+	}
+	assert.Equal(t, []string{
+		"traefik/http/routers/old/rule",
+		"traefik/http/routers/web/tls",
+		"traefik/http/services/old/loadbalancer/servers/0/url",
+	}, deletes, "keys of removed targets and stale keys are deleted")
+
+	assert.Equal(t, []string{
+		"traefik/http/routers/manual/rule",
+		"traefik/http/services/webapp/loadbalancer/servers/0/url",
+	}, plan.Foreign, "keys of unknown targets are left alone")
+
+	plan = diffKeys([]*traffikey.Target{web}, nil, keysForTarget(web))
+	assert.True(t, plan.Empty())
+}
+
+func TestDiffKeysSharedMiddlewares(t *testing.T) {
+	auth := &traffikey.Middleware{Name: "auth", Kind: "basicauth", Values: map[string]string{"users": "a:b"}}
+	headers := &traffikey.Middleware{Name: "headers", Kind: "headers", Values: map[string]string{"sslredirect": "true"}}
+	old := &traffikey.Target{Name: "old", Type: "http", Prefix: "traefik", Middlewares: []*traffikey.Middleware{auth, headers}}
+
+	live := keysForTarget(old)
+	// A router traffikey doesn't manage uses the auth middleware
+	live["traefik/http/routers/manual/rule"] = "Host(`manual`)"
+	live["traefik/http/routers/manual/middlewares"] = "auth"
+
+	plan := diffKeys(nil, []*traffikey.Target{old}, live)
+
+	var deletes []string
+	for _, c := range plan.Deletes {
+		deletes = append(deletes, c.Key)
+	}
+	assert.Contains(t, deletes, "traefik/http/middlewares/headers/headers/sslredirect")
+	assert.Contains(t, deletes, "traefik/http/routers/old/middlewares")
+	assert.NotContains(t, deletes, "traefik/http/middlewares/auth/basicauth/users", "middlewares used by other routers are kept")
+	assert.Contains(t, plan.Foreign, "traefik/http/middlewares/auth/basicauth/users")
+}
@@ -0,0 +1,227 @@
+package keymate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	etcd "go.etcd.io/etcd/client/v3"
+
+	"github.com/numkem/traffikey"
+)
+
+// PrefixCopy copies or moves targets from a prefix to another
+type PrefixCopy struct {
+	Source      string
+	Destination string
+	// Only copy the targets with these names or types, all of them when empty
+	Names []string
+	Types []string
+	// Delete the keys of the source once copied
+	Move bool
+	// Replace the keys of the destination holding another value
+	Overwrite bool
+}
+
+// PrefixPlan is what copying a prefix changes
+type PrefixPlan struct {
+	*Plan
+	// State once the targets are copied, nil when there is no state
+	State *traffikey.Config `json:"state"`
+	// Targets of the state that were copied
+	Targets []string `json:"targets"`
+}
+
+// selectKeys returns the keys of the prefix belonging to the targets with the
+// names and types. The middlewares of the selected routers are included.
+func selectKeys(prefix string, live etcdKeyValue, names []string, types []string) []string {
+	var keys []string
+	middlewares := make(map[string]bool)
+	for key := range live {
+		ss := strings.SplitN(strings.TrimPrefix(key, prefix+"/"), "/", 4)
+		if len(types) > 0 && !slices.Contains(types, ss[0]) {
+			continue
+		}
+
+		if len(names) == 0 {
+			keys = append(keys, key)
+			continue
+		}
+
+		if len(ss) < 3 || (ss[1] != "routers" && ss[1] != "services") || !slices.Contains(names, ss[2]) {
+			continue
+		}
+		keys = append(keys, key)
+
+		if ss[1] == "routers" && len(ss) == 4 && (ss[3] == "middlewares" || strings.HasPrefix(ss[3], "middlewares/")) {
+			for _, mw := range listValue(live, fmt.Sprintf("%s/%s/routers/%s/middlewares", prefix, ss[0], ss[2])) {
+				middlewares[ss[0]+"/middlewares/"+mw+"/"] = true
+			}
+		}
+	}
+
+	for mw := range middlewares {
+		for key := range live {
+			if strings.HasPrefix(key, prefix+"/"+mw) && !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// movedKeys returns the selected keys of the prefix that moving them deletes.
+// The middlewares are shared by name, the ones the routers left in the prefix
+// still use are copied but kept.
+func movedKeys(prefix string, live etcdKeyValue, selected []string) []string {
+	used := make(map[string]bool)
+	seen := make(map[string]bool)
+	for key := range live {
+		ss := strings.SplitN(strings.TrimPrefix(key, prefix+"/"), "/", 4)
+		if len(ss) < 3 || ss[1] != "routers" || slices.Contains(selected, key) {
+			continue
+		}
+
+		router := ss[0] + "/routers/" + ss[2]
+		if seen[router] {
+			continue
+		}
+		seen[router] = true
+
+		for _, mw := range listValue(live, fmt.Sprintf("%s/%s/middlewares", prefix, router)) {
+			used[ss[0]+"/middlewares/"+mw] = true
+		}
+	}
+
+	var keys []string
+	for _, key := range selected {
+		ss := strings.SplitN(strings.TrimPrefix(key, prefix+"/"), "/", 4)
+		if len(ss) >= 3 && ss[1] == "middlewares" && used[ss[0]+"/middlewares/"+ss[2]] {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// copyState returns the state with the selected targets of the source copied
+// or moved to the destination, along with their keys
+func copyState(state *traffikey.Config, c *PrefixCopy) (*traffikey.Config, []string) {
+	copied := *state
+	copied.Targets = nil
+
+	var targets []string
+	for _, target := range state.Targets {
+		t := *target
+		if t.Prefix == "" {
+			t.Prefix = state.Traefik.DefaultPrefix
+		}
+		if t.Type == "" {
+			t.Type = "http"
+		}
+
+		selected := t.Prefix == c.Source &&
+			(len(c.Names) == 0 || slices.Contains(c.Names, t.Name)) &&
+			(len(c.Types) == 0 || slices.Contains(c.Types, t.Type))
+		if !selected {
+			copied.Targets = append(copied.Targets, target)
+			continue
+		}
+
+		t.Prefix = c.Destination
+		targets = append(targets, TargetKey(&t))
+		if !c.Move {
+			copied.Targets = append(copied.Targets, target)
+		}
+		copied.Targets = append(copied.Targets, &t)
+	}
+
+	return &copied, targets
+}
+
+// PlanPrefixCopy returns the keys and state that copying the prefix changes
+func (m *EtcdKeymateManager) PlanPrefixCopy(ctx context.Context, c *PrefixCopy) (*PrefixPlan, error) {
+	for _, prefix := range []string{c.Source, c.Destination} {
+		if prefix == "" || prefix == ETCD_CONFIG_PREFIX || strings.HasPrefix(prefix, ETCD_CONFIG_PREFIX+"/") {
+			return nil, fmt.Errorf("invalid prefix %q", prefix)
+		}
+	}
+	if c.Source == c.Destination || strings.HasPrefix(c.Source, c.Destination+"/") || strings.HasPrefix(c.Destination, c.Source+"/") {
+		return nil, fmt.Errorf("prefixes %s and %s overlap", c.Source, c.Destination)
+	}
+
+	live, err := m.liveKeys(ctx, []string{c.Source, c.Destination})
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &PrefixPlan{Plan: new(Plan)}
+	var conflicts []string
+	selected := selectKeys(c.Source, live, c.Names, c.Types)
+	for _, key := range selected {
+		dst := c.Destination + strings.TrimPrefix(key, c.Source)
+
+		current, ok := live[dst]
+		if ok && current != live[key] && !c.Overwrite {
+			conflicts = append(conflicts, dst)
+		}
+		if !ok || current != live[key] {
+			plan.Puts = append(plan.Puts, &KeyChange{Key: dst, Current: current, Wanted: live[key]})
+		}
+	}
+
+	if c.Move {
+		for _, key := range movedKeys(c.Source, live, selected) {
+			plan.Deletes = append(plan.Deletes, &KeyChange{Key: key, Current: live[key]})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("keys of prefix %s already hold other values: %s", c.Destination, strings.Join(conflicts, ", "))
+	}
+
+	state, err := m.GetState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		state.SetDefaults()
+		plan.State, plan.Targets = copyState(state, c)
+	}
+
+	return plan, nil
+}
+
+// ApplyPrefixCopy writes the keys and the state of the plan in a single transaction
+func (m *EtcdKeymateManager) ApplyPrefixCopy(ctx context.Context, plan *PrefixPlan) error {
+	var ops []etcd.Op
+	for _, c := range plan.Puts {
+		ops = append(ops, etcd.OpPut(c.Key, c.Wanted))
+	}
+	for _, c := range plan.Deletes {
+		ops = append(ops, etcd.OpDelete(c.Key))
+	}
+
+	// The state, its revision and the deletion of the old revisions and state
+	// are written in the same transaction
+	if len(ops)+4 > ETCD_MAX_TXN_OPS {
+		return fmt.Errorf("%d keys can't be changed at once, etcd allows %d operations per transaction: select fewer targets", len(ops), ETCD_MAX_TXN_OPS-4)
+	}
+
+	if plan.State == nil {
+		_, err := m.client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return fmt.Errorf("failed to copy keys: %v", err)
+		}
+
+		return nil
+	}
+
+	return m.saveRevision(ctx, plan.State, ops...)
+}
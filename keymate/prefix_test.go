@@ -0,0 +1,56 @@
+package keymate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/numkem/traffikey"
+)
+
+func TestSelectKeys(t *testing.T) {
+	live := etcdKeyValue{
+		"traefik/http/routers/web/rule":                        "Host(`web`)",
+		"traefik/http/routers/web/middlewares":                 "auth",
+		"traefik/http/services/web/loadbalancer/servers/0/url": "http://10.0.0.1",
+		"traefik/http/middlewares/auth/basicauth/users":        "a:b",
+		"traefik/http/middlewares/other/stripprefix/prefixes":  "/other",
+		"traefik/http/routers/api/rule":                        "Host(`api`)",
+		"traefik/tcp/routers/ssh/rule":                         "HostSNI(`*`)",
+	}
+
+	assert.Len(t, selectKeys("traefik", live, nil, nil), len(live), "everything without filters")
+	assert.Equal(t, []string{"traefik/tcp/routers/ssh/rule"}, selectKeys("traefik", live, nil, []string{"tcp"}))
+	assert.Equal(t, []string{
+		"traefik/http/middlewares/auth/basicauth/users",
+		"traefik/http/routers/web/middlewares",
+		"traefik/http/routers/web/rule",
+		"traefik/http/services/web/loadbalancer/servers/0/url",
+	}, selectKeys("traefik", live, []string{"web"}, nil), "middlewares of the selected routers are included")
+
+	live["traefik/http/routers/api/middlewares/0"] = "auth"
+	assert.Equal(t, []string{
+		"traefik/http/routers/web/middlewares",
+		"traefik/http/routers/web/rule",
+		"traefik/http/services/web/loadbalancer/servers/0/url",
+	}, movedKeys("traefik", live, selectKeys("traefik", live, []string{"web"}, nil)), "middlewares used by the routers left behind are kept")
+	assert.Contains(t, movedKeys("traefik", live, selectKeys("traefik", live, []string{"web", "api"}, nil)), "traefik/http/middlewares/auth/basicauth/users")
+}
+
+func TestCopyState(t *testing.T) {
+	state := &traffikey.Config{}
+	state.SetDefaults()
+	state.Traefik.DefaultPrefix = "traefik"
+	state.Targets = []*traffikey.Target{{Name: "web"}, {Name: "api", Prefix: "traefik"}, {Name: "other", Prefix: "other"}}
+
+	copied, targets := copyState(state, &PrefixCopy{Source: "traefik", Destination: "public", Names: []string{"web"}, Move: true})
+	assert.Equal(t, []string{"public/http/web"}, targets)
+	require.Len(t, copied.Targets, 3)
+	assert.Equal(t, "public", copied.Targets[0].Prefix)
+	assert.Equal(t, "", state.Targets[0].Prefix, "the state isn't changed")
+
+	copied, targets = copyState(state, &PrefixCopy{Source: "traefik", Destination: "public"})
+	assert.Equal(t, []string{"public/http/web", "public/http/api"}, targets)
+	assert.Len(t, copied.Targets, 5, "copies are added")
+}
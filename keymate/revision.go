@@ -0,0 +1,261 @@
+package keymate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	etcd "go.etcd.io/etcd/client/v3"
+	"golang.org/x/exp/maps"
+
+	"github.com/numkem/traffikey"
+)
+
+const ETCD_REVISION_PREFIX = ETCD_CONFIG_PREFIX + "/revisions"
+
+// Number of times saving a revision is tried when others are saved at the same time
+const REVISION_SAVE_ATTEMPTS = 5
+
+// Number of revisions kept when the configuration doesn't say
+const DEFAULT_REVISIONS_KEPT = 100
+
+type authorKey struct{}
+
+// WithAuthor sets the author of the revisions saved with the context
+func WithAuthor(ctx context.Context, author string) context.Context {
+	return context.WithValue(ctx, authorKey{}, author)
+}
+
+// authorOf returns the author set on the context, the user running traffikey otherwise
+func authorOf(ctx context.Context) string {
+	if author, ok := ctx.Value(authorKey{}).(string); ok {
+		return author
+	}
+
+	author := "unknown"
+	if u, err := user.Current(); err == nil {
+		author = u.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		author += "@" + hostname
+	}
+
+	return author
+}
+
+// Name of the state of the targets managed through the API of serve. It is
+// kept apart from the state of the host so that apply doesn't remove them.
+const API_STATE = "_api"
+
+type stateNameKey struct{}
+
+// WithStateName makes the state and the revisions read and saved with the
+// context the ones of the name instead of the ones of this host
+func WithStateName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, stateNameKey{}, name)
+}
+
+// StateName returns the name of the state set on the context, empty for the state of this host
+func StateName(ctx context.Context) string {
+	name, _ := ctx.Value(stateNameKey{}).(string)
+	return name
+}
+
+// stateNameOf returns the name of the state set on the context, the hostname
+// otherwise, and whether it is the state of the host
+func stateNameOf(ctx context.Context) (string, bool, error) {
+	if name := StateName(ctx); name != "" {
+		return name, false, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get hostname: %v", err)
+	}
+
+	return hostname, true, nil
+}
+
+func revisionsPrefix(ctx context.Context) (string, error) {
+	name, _, err := stateNameOf(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s/", ETCD_REVISION_PREFIX, name), nil
+}
+
+// Numbers are padded so that the keys are sorted like the numbers
+func revisionKey(prefix string, number int) string {
+	return fmt.Sprintf("%s%010d", prefix, number)
+}
+
+// stateKeys returns the keys the valid targets of the configuration produce
+func (m *EtcdKeymateManager) stateKeys(cfg *traffikey.Config) map[string]string {
+	keys := make(map[string]string)
+	for _, target := range cfg.Targets {
+		t := *target
+		if m.normalizeTargets(cfg, []*traffikey.Target{&t}) != nil {
+			continue
+		}
+
+		maps.Copy(keys, keysForTarget(&t))
+	}
+
+	return keys
+}
+
+// lastRevision returns the number of the last saved revision, 0 when there are none
+func (m *EtcdKeymateManager) lastRevision(ctx context.Context, prefix string) (int, error) {
+	resp, err := m.client.Get(ctx, prefix, etcd.WithPrefix(), etcd.WithSort(etcd.SortByKey, etcd.SortDescend), etcd.WithLimit(1), etcd.WithKeysOnly())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last revision: %v", err)
+	}
+
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+
+	return strconv.Atoi(strings.TrimPrefix(string(resp.Kvs[0].Key), prefix))
+}
+
+// pruneRevisions returns the operations deleting the revisions older than the
+// last ones kept once the revision with the number is saved
+func pruneRevisions(prefix string, number int, kept int) []etcd.Op {
+	oldest := number - kept + 1
+	if oldest <= 1 {
+		return nil
+	}
+
+	return []etcd.Op{etcd.OpDelete(revisionKey(prefix, 0), etcd.WithRange(revisionKey(prefix, oldest)))}
+}
+
+// saveRevision writes the state with a new revision and the operations in the
+// same transaction, the oldest revisions and the state saved by previous
+// versions are deleted along
+func (m *EtcdKeymateManager) saveRevision(ctx context.Context, cfg *traffikey.Config, ops ...etcd.Op) error {
+	// The interpolated secrets are saved as their ${...} expressions
+	cfg = cfg.Redacted()
+
+	state, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	prefix, err := revisionsPrefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The state saved by previous versions is moved to its own prefix
+	key, legacy, err := stateLocation(ctx)
+	if err != nil {
+		return err
+	}
+	var cleanup []etcd.Op
+	if legacy != "" {
+		cleanup = append(cleanup, etcd.OpDelete(legacy))
+	}
+
+	kept := m.cfg.Etcd.RevisionsKept
+	if kept <= 0 {
+		kept = DEFAULT_REVISIONS_KEPT
+	}
+
+	for attempt := 0; attempt < REVISION_SAVE_ATTEMPTS; attempt++ {
+		last, err := m.lastRevision(ctx, prefix)
+		if err != nil {
+			return err
+		}
+
+		rev, err := json.Marshal(&traffikey.Revision{
+			Number: last + 1,
+			Config: cfg,
+			Keys:   m.stateKeys(cfg),
+			Author: authorOf(ctx),
+			Time:   time.Now(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal revision: %v", err)
+		}
+
+		// Another revision with the same number could have been saved in between
+		revKey := revisionKey(prefix, last+1)
+		resp, err := m.client.Txn(ctx).
+			If(etcd.Compare(etcd.CreateRevision(revKey), "=", 0)).
+			Then(append(append(append([]etcd.Op{etcd.OpPut(key, string(state)), etcd.OpPut(revKey, string(rev))}, cleanup...), pruneRevisions(prefix, last+1, kept)...), ops...)...).
+			Commit()
+		if err != nil {
+			return fmt.Errorf("failed to save etcd state: %v", err)
+		}
+
+		if resp.Succeeded {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to save etcd state: revisions saved at the same time")
+}
+
+func unmarshalRevision(kv []byte, modRevision int64) (*traffikey.Revision, error) {
+	rev := new(traffikey.Revision)
+	err := json.Unmarshal(kv, rev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal revision: %v", err)
+	}
+	rev.EtcdRevision = modRevision
+	if rev.Config != nil {
+		rev.Config.SetDefaults()
+	}
+
+	return rev, nil
+}
+
+// ListRevisions returns the saved revisions from the oldest
+func (m *EtcdKeymateManager) ListRevisions(ctx context.Context) ([]*traffikey.Revision, error) {
+	prefix, err := revisionsPrefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.client.Get(ctx, prefix, etcd.WithPrefix(), etcd.WithSort(etcd.SortByKey, etcd.SortAscend))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revisions: %v", err)
+	}
+
+	var revisions []*traffikey.Revision
+	for _, kv := range resp.Kvs {
+		rev, err := unmarshalRevision(kv.Value, kv.ModRevision)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, nil
+}
+
+// GetRevision returns the revision, nil when it doesn't exist
+func (m *EtcdKeymateManager) GetRevision(ctx context.Context, number int) (*traffikey.Revision, error) {
+	prefix, err := revisionsPrefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.client.Get(ctx, revisionKey(prefix, number))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revision %d: %v", number, err)
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, nil
+	}
+
+	return unmarshalRevision(resp.Kvs[0].Value, resp.Kvs[0].ModRevision)
+}
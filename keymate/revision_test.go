@@ -0,0 +1,21 @@
+package keymate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneRevisions(t *testing.T) {
+	prefix := "traefik/config/revisions/host/"
+
+	assert.Empty(t, pruneRevisions(prefix, 3, 5), "fewer revisions than the ones kept")
+	assert.Empty(t, pruneRevisions(prefix, 5, 5))
+
+	ops := pruneRevisions(prefix, 8, 5)
+	require.Len(t, ops, 1)
+	assert.True(t, ops[0].IsDelete())
+	assert.Equal(t, revisionKey(prefix, 0), string(ops[0].KeyBytes()))
+	assert.Equal(t, revisionKey(prefix, 4), string(ops[0].RangeBytes()), "revisions 4 to 8 are kept")
+}
@@ -0,0 +1,25 @@
+package traffikey
+
+type NotifierConfig struct {
+	// One of webhook, slack, matrix or smtp
+	Kind string `json:"kind"`
+
+	// URL to post to for webhooks
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+
+	SMTP *SMTPConfig `json:"smtp"`
+
+	// Minimum delay between two notifications about the same target
+	RateLimit Duration `json:"rate_limit"`
+	// Events happening within this delay are sent as a single notification
+	GroupWindow Duration `json:"group_window"`
+}
+
+type SMTPConfig struct {
+	Address  string   `json:"address"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
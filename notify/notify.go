@@ -0,0 +1,329 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/numkem/traffikey"
+)
+
+const (
+	DEFAULT_GROUP_WINDOW = 10 * time.Second
+	DEFAULT_RATE_LIMIT   = 5 * time.Minute
+
+	SEND_TIMEOUT = 10 * time.Second
+)
+
+const (
+	KIND_STATE       = "state"
+	KIND_CERTIFICATE = "certificate"
+)
+
+// Event is a change of state of a monitored target. Up is false when
+// something is wrong and true once it's back to normal.
+type Event struct {
+	// What changed on the target, defaults to KIND_STATE
+	Kind string `json:"kind"`
+	// What the event is about within the target, like the address of a certificate
+	Subject    string    `json:"subject,omitempty"`
+	Target     string    `json:"target"`
+	Prefix     string    `json:"prefix"`
+	Up         bool      `json:"up"`
+	AliveURLs  []string  `json:"alive_urls"`
+	ServerURLs []string  `json:"server_urls"`
+	Time       time.Time `json:"time"`
+	Message    string    `json:"message"`
+}
+
+func (e *Event) String() string {
+	if e.Message != "" {
+		return e.Message
+	}
+
+	if e.Up {
+		return fmt.Sprintf("target %s recovered (%d/%d servers alive)", e.Target, len(e.AliveURLs), len(e.ServerURLs))
+	}
+
+	return fmt.Sprintf("target %s is DOWN (%d/%d servers alive)", e.Target, len(e.AliveURLs), len(e.ServerURLs))
+}
+
+// key identifies what the event is about for rate limiting
+func (e *Event) key() string {
+	return fmt.Sprintf("%s/%s/%s", e.Kind, e.Target, e.Subject)
+}
+
+// Sink sends a group of events somewhere
+type Sink interface {
+	Send(ctx context.Context, events []*Event) error
+}
+
+func NewSink(cfg *traffikey.NotifierConfig) (Sink, error) {
+	switch cfg.Kind {
+	case "webhook", "slack", "matrix":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("url cannot be empty for %s notifiers", cfg.Kind)
+		}
+
+		return &webhookSink{format: cfg.Kind, url: cfg.URL, headers: cfg.Headers}, nil
+
+	case "smtp":
+		if cfg.SMTP == nil || cfg.SMTP.Address == "" || len(cfg.SMTP.To) == 0 {
+			return nil, fmt.Errorf("smtp notifiers require an address and at least one recipient")
+		}
+
+		return &smtpSink{cfg: cfg.SMTP}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown notifier kind %s", cfg.Kind)
+	}
+}
+
+// Summary renders a group of events as a short human readable text
+func Summary(events []*Event) string {
+	if len(events) == 1 {
+		return events[0].String()
+	}
+
+	var down, up, other []string
+	for _, ev := range events {
+		switch {
+		case ev.Kind != KIND_STATE:
+			other = append(other, ev.String())
+		case ev.Up:
+			up = append(up, ev.Target)
+		default:
+			down = append(down, ev.Target)
+		}
+	}
+
+	var parts []string
+	if len(down) > 0 {
+		parts = append(parts, fmt.Sprintf("%d targets are DOWN: %s", len(down), strings.Join(down, ", ")))
+	}
+	if len(up) > 0 {
+		parts = append(parts, fmt.Sprintf("%d targets recovered: %s", len(up), strings.Join(up, ", ")))
+	}
+	parts = append(parts, other...)
+
+	return strings.Join(parts, "; ")
+}
+
+// dispatcher groups and rate limits the events sent to a single sink
+type dispatcher struct {
+	name        string
+	sink        Sink
+	rateLimit   time.Duration
+	groupWindow time.Duration
+
+	mu      sync.Mutex
+	pending []*Event
+	timer   *time.Timer
+	// Last event sent for each kind of event of each target
+	lastSent map[string]*Event
+	// Failures held back by the rate limit, sent once it ends unless they recover first
+	deferred map[string]*deferredEvent
+}
+
+type deferredEvent struct {
+	ev    *Event
+	timer *time.Timer
+}
+
+func (d *dispatcher) add(ev *Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := ev.key()
+	if deferred, ok := d.deferred[key]; ok && ev.Up {
+		// The failure recovered before it could be sent
+		deferred.timer.Stop()
+		delete(d.deferred, key)
+	}
+
+	last, notified := d.lastSent[key]
+	switch {
+	case ev.Up && (!notified || last.Up):
+		// Only send a recovery when the failure was notified
+		return
+
+	case !ev.Up && notified && !last.Up:
+		// Already notified about this failure
+		return
+
+	case !ev.Up && notified && ev.Time.Sub(last.Time) < d.rateLimit:
+		if _, ok := d.deferred[key]; ok {
+			return
+		}
+
+		log.WithField("target", ev.Target).Debugf("rate limiting notification to %s", d.name)
+		d.deferred[key] = &deferredEvent{
+			ev:    ev,
+			timer: time.AfterFunc(d.rateLimit-ev.Time.Sub(last.Time), func() { d.release(key) }),
+		}
+		return
+	}
+
+	d.queue(ev)
+}
+
+// queue adds the event to the next group sent, the lock must be held
+func (d *dispatcher) queue(ev *Event) {
+	d.lastSent[ev.key()] = ev
+	d.pending = append(d.pending, ev)
+
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.groupWindow, d.flush)
+	}
+}
+
+// release sends the failure held back by the rate limit once it ended
+func (d *dispatcher) release(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deferred, ok := d.deferred[key]
+	if !ok {
+		return
+	}
+
+	delete(d.deferred, key)
+	d.queue(deferred.ev)
+}
+
+// takeOver continues where the previous dispatcher left: the events it sent
+// still dedupe and rate limit the next ones, and the failures it held back are
+// sent once the rate limit ends
+func (d *dispatcher) takeOver(previous *dispatcher) {
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, ev := range previous.lastSent {
+		d.lastSent[key] = ev
+	}
+
+	for key, deferred := range previous.deferred {
+		key := key
+		deferred.timer.Stop()
+		delete(previous.deferred, key)
+
+		end := d.lastSent[key].Time.Add(d.rateLimit)
+		d.deferred[key] = &deferredEvent{
+			ev:    deferred.ev,
+			timer: time.AfterFunc(time.Until(end), func() { d.release(key) }),
+		}
+	}
+}
+
+func (d *dispatcher) flush() {
+	d.mu.Lock()
+	events := d.pending
+	d.pending = nil
+	d.timer = nil
+	d.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), SEND_TIMEOUT)
+	defer cancel()
+
+	err := d.sink.Send(ctx, events)
+	if err != nil {
+		log.Errorf("failed to send notification to %s: %v", d.name, err)
+	}
+}
+
+// Notifier dispatches events to the named sinks of the configuration
+type Notifier struct {
+	dispatchers map[string]*dispatcher
+}
+
+func NewNotifier(cfgs map[string]*traffikey.NotifierConfig) (*Notifier, error) {
+	n := &Notifier{dispatchers: make(map[string]*dispatcher)}
+
+	for name, cfg := range cfgs {
+		sink, err := NewSink(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notifier %s: %v", name, err)
+		}
+
+		d := &dispatcher{
+			name:        name,
+			sink:        sink,
+			rateLimit:   time.Duration(cfg.RateLimit),
+			groupWindow: time.Duration(cfg.GroupWindow),
+			lastSent:    make(map[string]*Event),
+			deferred:    make(map[string]*deferredEvent),
+		}
+		if d.rateLimit == 0 {
+			d.rateLimit = DEFAULT_RATE_LIMIT
+		}
+		if d.groupWindow == 0 {
+			d.groupWindow = DEFAULT_GROUP_WINDOW
+		}
+
+		n.dispatchers[name] = d
+	}
+
+	return n, nil
+}
+
+// TakeOver makes the notifiers continue where the ones with the same names of
+// the previous notifier left, so that a reload doesn't forget which failures
+// were notified. It must be called before the previous notifier is closed.
+func (n *Notifier) TakeOver(previous *Notifier) {
+	for name, d := range n.dispatchers {
+		if p, ok := previous.dispatchers[name]; ok {
+			d.takeOver(p)
+		}
+	}
+}
+
+// Notify queues the event for each of the named notifiers
+func (n *Notifier) Notify(names []string, ev *Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Kind == "" {
+		ev.Kind = KIND_STATE
+	}
+
+	for _, name := range names {
+		d, ok := n.dispatchers[name]
+		if !ok {
+			log.WithField("target", ev.Target).Warnf("unknown notifier %s", name)
+			continue
+		}
+
+		d.add(ev)
+	}
+}
+
+// Close sends all the pending events right away, including the rate limited ones
+func (n *Notifier) Close() {
+	for _, d := range n.dispatchers {
+		d.mu.Lock()
+		if d.timer != nil {
+			d.timer.Stop()
+		}
+		for key, deferred := range d.deferred {
+			deferred.timer.Stop()
+			delete(d.deferred, key)
+			d.pending = append(d.pending, deferred.ev)
+		}
+		d.mu.Unlock()
+
+		d.flush()
+	}
+}
@@ -0,0 +1,237 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/numkem/traffikey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func webhookServer(t *testing.T) (*httptest.Server, chan map[string]interface{}) {
+	payloads := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		payloads <- payload
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, payloads
+}
+
+func TestWebhookGroupsEvents(t *testing.T) {
+	srv, payloads := webhookServer(t)
+
+	n, err := NewNotifier(map[string]*traffikey.NotifierConfig{
+		"hook": {Kind: "webhook", URL: srv.URL, GroupWindow: traffikey.Duration(50 * time.Millisecond)},
+	})
+	require.NoError(t, err)
+
+	n.Notify([]string{"hook"}, &Event{Target: "a"})
+	n.Notify([]string{"hook"}, &Event{Target: "b"})
+
+	select {
+	case payload := <-payloads:
+		assert.Len(t, payload["events"], 2, "simultaneous failures should be grouped")
+		assert.Equal(t, "2 targets are DOWN: a, b", payload["summary"])
+	case <-time.After(time.Second):
+		t.Fatal("webhook wasn't called")
+	}
+}
+
+func TestSlackFormat(t *testing.T) {
+	srv, payloads := webhookServer(t)
+
+	n, err := NewNotifier(map[string]*traffikey.NotifierConfig{
+		"slack": {Kind: "slack", URL: srv.URL},
+	})
+	require.NoError(t, err)
+
+	n.Notify([]string{"slack"}, &Event{Target: "a", ServerURLs: []string{"http://a"}})
+	n.Close()
+
+	payload := <-payloads
+	assert.Equal(t, "target a is DOWN (0/1 servers alive)", payload["text"])
+}
+
+func TestRateLimitAndRecovery(t *testing.T) {
+	sent := make(chan []*Event, 10)
+	d := &dispatcher{
+		name:        "test",
+		sink:        sinkFunc(func(events []*Event) { sent <- events }),
+		rateLimit:   time.Minute,
+		groupWindow: time.Hour,
+		lastSent:    make(map[string]*Event),
+		deferred:    make(map[string]*deferredEvent),
+	}
+
+	now := time.Now()
+	d.add(&Event{Target: "a", Up: true, Time: now})
+	assert.Empty(t, d.pending, "recovery of an unnotified failure shouldn't be sent")
+
+	d.add(&Event{Target: "a", Time: now})
+	d.add(&Event{Target: "a", Up: true, Time: now.Add(time.Second)})
+	d.add(&Event{Target: "a", Time: now.Add(2 * time.Second)})
+	d.flush()
+
+	events := <-sent
+	require.Len(t, events, 2, "flapping target should be rate limited")
+	assert.False(t, events[0].Up)
+	assert.True(t, events[1].Up, "recovery should be sent")
+}
+
+func TestRateLimitedFailureIsSentLater(t *testing.T) {
+	sent := make(chan []*Event, 10)
+	d := &dispatcher{
+		name:        "test",
+		sink:        sinkFunc(func(events []*Event) { sent <- events }),
+		rateLimit:   100 * time.Millisecond,
+		groupWindow: 10 * time.Millisecond,
+		lastSent:    make(map[string]*Event),
+		deferred:    make(map[string]*deferredEvent),
+	}
+
+	now := time.Now()
+	d.add(&Event{Target: "a", Time: now})
+	d.add(&Event{Target: "a", Up: true, Time: now})
+	require.Len(t, <-sent, 2)
+
+	d.add(&Event{Target: "a", Time: time.Now()})
+	select {
+	case events := <-sent:
+		require.Len(t, events, 1, "the failure should be sent once the rate limit ends")
+		assert.False(t, events[0].Up)
+	case <-time.After(time.Second):
+		t.Fatal("rate limited failure wasn't sent")
+	}
+
+	d.add(&Event{Target: "a", Up: true, Time: time.Now()})
+	require.Len(t, <-sent, 1)
+	d.add(&Event{Target: "a", Time: time.Now()})
+	d.add(&Event{Target: "a", Up: true, Time: time.Now()})
+	select {
+	case events := <-sent:
+		t.Fatalf("recovered failure shouldn't be sent: %v", events)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestTakeOver(t *testing.T) {
+	srv, payloads := webhookServer(t)
+	cfgs := map[string]*traffikey.NotifierConfig{
+		"hook": {Kind: "webhook", URL: srv.URL, GroupWindow: traffikey.Duration(10 * time.Millisecond), RateLimit: traffikey.Duration(200 * time.Millisecond)},
+	}
+
+	previous, err := NewNotifier(cfgs)
+	require.NoError(t, err)
+	previous.Notify([]string{"hook"}, &Event{Target: "a"})
+	previous.Notify([]string{"hook"}, &Event{Target: "b"})
+	<-payloads
+	previous.Notify([]string{"hook"}, &Event{Target: "b", Up: true})
+	<-payloads
+	// Rate limited until the previous failure of b is old enough
+	previous.Notify([]string{"hook"}, &Event{Target: "b"})
+
+	n, err := NewNotifier(cfgs)
+	require.NoError(t, err)
+	n.TakeOver(previous)
+	previous.Close()
+
+	n.Notify([]string{"hook"}, &Event{Target: "a"})
+	n.Notify([]string{"hook"}, &Event{Target: "a", Up: true})
+	select {
+	case payload := <-payloads:
+		assert.Equal(t, "target a recovered (0/0 servers alive)", payload["summary"], "the failure was notified before the reload")
+	case <-time.After(time.Second):
+		t.Fatal("recovery wasn't sent")
+	}
+
+	select {
+	case payload := <-payloads:
+		assert.Equal(t, "target b is DOWN (0/0 servers alive)", payload["summary"], "the rate limited failure is sent once the rate limit ends")
+	case <-time.After(time.Second):
+		t.Fatal("rate limited failure wasn't sent")
+	}
+}
+
+type sinkFunc func(events []*Event)
+
+func (f sinkFunc) Send(ctx context.Context, events []*Event) error {
+	f(events)
+	return nil
+}
+
+// smtpServer is a minimal SMTP server that accepts a single message
+func smtpServer(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ready")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 localhost")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotLines()
+				messages <- strings.Join(data, "\n")
+				tp.PrintfLine("250 ok")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	return l.Addr().String(), messages
+}
+
+func TestSMTP(t *testing.T) {
+	addr, messages := smtpServer(t)
+
+	sink, err := NewSink(&traffikey.NotifierConfig{
+		Kind: "smtp",
+		SMTP: &traffikey.SMTPConfig{Address: addr, From: "traffikey@localhost", To: []string{"ops@localhost"}},
+	})
+	require.NoError(t, err)
+
+	err = sink.Send(context.Background(), []*Event{{Target: "a", Time: time.Now()}})
+	require.NoError(t, err)
+
+	msg := <-messages
+	scanner := bufio.NewScanner(strings.NewReader(msg))
+	var subject string
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "Subject: ") {
+			subject = scanner.Text()
+		}
+	}
+	assert.Equal(t, "Subject: [traffikey] target a is DOWN (0/0 servers alive)", subject)
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/numkem/traffikey"
+)
+
+// smtpSink sends the events by email
+type smtpSink struct {
+	cfg *traffikey.SMTPConfig
+}
+
+func (s *smtpSink) message(events []*Event) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: [traffikey] %s\r\n", Summary(events))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	for _, ev := range events {
+		fmt.Fprintf(&b, "%s: %s\r\n", ev.Time.Format(time.RFC3339), ev)
+	}
+
+	return []byte(b.String())
+}
+
+func (s *smtpSink) Send(ctx context.Context, events []*Event) error {
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(s.cfg.Address)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address %s: %v", s.cfg.Address, err)
+		}
+
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+
+	// smtp.SendMail doesn't take a context, make sure we don't block forever
+	errc := make(chan error, 1)
+	go func() {
+		errc <- smtp.SendMail(s.cfg.Address, auth, s.cfg.From, s.cfg.To, s.message(events))
+	}()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %v", err)
+		}
+		return nil
+
+	case <-ctx.Done():
+		return fmt.Errorf("failed to send email: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,92 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// webhookSink posts the events as JSON. The format is either the generic
+// webhook one or one that Slack or Matrix (hookshot) incoming webhooks accept.
+type webhookSink struct {
+	format  string
+	url     string
+	headers map[string]string
+}
+
+type webhookPayload struct {
+	Summary string   `json:"summary"`
+	Events  []*Event `json:"events"`
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+type matrixPayload struct {
+	Text string `json:"text"`
+	HTML string `json:"html"`
+}
+
+func (s *webhookSink) payload(events []*Event) interface{} {
+	summary := Summary(events)
+
+	switch s.format {
+	case "slack":
+		lines := []string{summary}
+		if len(events) > 1 {
+			for _, ev := range events {
+				lines = append(lines, fmt.Sprintf("• %s", ev))
+			}
+		}
+
+		return &slackPayload{Text: strings.Join(lines, "\n")}
+
+	case "matrix":
+		items := make([]string, len(events))
+		for i, ev := range events {
+			items[i] = fmt.Sprintf("<li>%s</li>", html.EscapeString(ev.String()))
+		}
+
+		return &matrixPayload{
+			Text: summary,
+			HTML: fmt.Sprintf("<p>%s</p><ul>%s</ul>", html.EscapeString(summary), strings.Join(items, "")),
+		}
+
+	default:
+		return &webhookPayload{Summary: summary, Events: events}
+	}
+}
+
+func (s *webhookSink) Send(ctx context.Context, events []*Event) error {
+	body, err := json.Marshal(s.payload(events))
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+
+	return nil
+}
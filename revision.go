@@ -0,0 +1,50 @@
+package traffikey
+
+import (
+	"sort"
+	"time"
+)
+
+// Revision is a state saved by an apply, numbered in the order they were saved
+type Revision struct {
+	Number int     `json:"number"`
+	Config *Config `json:"config"`
+	// Keys and values the targets of the configuration produce
+	Keys   map[string]string `json:"keys"`
+	Author string            `json:"author"`
+	Time   time.Time         `json:"time"`
+	// Revision of etcd once the revision was saved, filled when it is read
+	EtcdRevision int64 `json:"etcd_revision,omitempty"`
+}
+
+// KeysDiff is the difference between the keys of two revisions
+type KeysDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// DiffKeys compares the keys of the revisions, each list is sorted
+func (r *Revision) DiffKeys(to *Revision) *KeysDiff {
+	diff := new(KeysDiff)
+	for key, value := range to.Keys {
+		old, ok := r.Keys[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+		} else if old != value {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+
+	for key := range r.Keys {
+		if _, ok := to.Keys[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	return diff
+}
@@ -0,0 +1,15 @@
+package traffikey
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevisionDiffKeys(t *testing.T) {
+	from := &Revision{Keys: map[string]string{"a": "1", "b": "2", "c": "3"}}
+	to := &Revision{Keys: map[string]string{"a": "1", "b": "20", "d": "4"}}
+
+	assert.Equal(t, &KeysDiff{Added: []string{"d"}, Removed: []string{"c"}, Changed: []string{"b"}}, from.DiffKeys(to))
+	assert.Equal(t, &KeysDiff{}, from.DiffKeys(from))
+}
@@ -11,4 +11,45 @@ type Target struct {
 	TLS          bool              `json:"tls"`
 	TLSExtraKeys map[string]string `json:"tls_extra_keys"`
 	Monitored    bool              `json:"monitored"`
+	// How the target is probed when monitored, unset values are taken from the monitor's configuration
+	HealthCheck *HealthCheck `json:"health_check"`
+	// Names of the notifiers to alert when the target goes down or recovers
+	Notify []string `json:"notify"`
+	// Prefixes or prefix groups the target is written to instead of Prefix
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Changes of the target for some of its prefixes or prefix groups, by name
+	Overrides map[string]*TargetOverride `json:"overrides,omitempty"`
+	// file:line of the configuration that defines the target, empty for the
+	// targets of the state and of the APIs
+	Source string `json:"-"`
+}
+
+// TargetOverride replaces the values of a target for a prefix, unset values are kept
+type TargetOverride struct {
+	Entrypoint   string            `json:"entrypoint"`
+	TLS          *bool             `json:"tls"`
+	TLSExtraKeys map[string]string `json:"tls_extra_keys"`
+}
+
+func (o *TargetOverride) apply(t *Target) {
+	if o == nil {
+		return
+	}
+
+	if o.Entrypoint != "" {
+		t.Entrypoint = o.Entrypoint
+	}
+	if o.TLS != nil {
+		t.TLS = *o.TLS
+	}
+	if len(o.TLSExtraKeys) > 0 {
+		keys := make(map[string]string)
+		for k, v := range t.TLSExtraKeys {
+			keys[k] = v
+		}
+		for k, v := range o.TLSExtraKeys {
+			keys[k] = v
+		}
+		t.TLSExtraKeys = keys
+	}
 }
@@ -0,0 +1,153 @@
+package traffikey
+
+import (
+	"fmt"
+	"strings"
+)
+
+// applyTemplates replaces the extends of the targets of the document by the
+// templates they name. The sources are the file:line of each target.
+func applyTemplates(doc map[string]interface{}, sources []string) error {
+	templates, ok := doc["templates"].(map[string]interface{})
+	if !ok && doc["templates"] != nil {
+		return fmt.Errorf("templates should be an object")
+	}
+	delete(doc, "templates")
+
+	targets, _ := doc["targets"].([]interface{})
+	for i, t := range targets {
+		target, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		extended, err := extendTarget(templates, target, nil)
+		if err != nil {
+			name, _ := target["name"].(string)
+			if i < len(sources) {
+				return fmt.Errorf("%s: target %s: %v", sources[i], name, err)
+			}
+			return fmt.Errorf("target %s: %v", name, err)
+		}
+
+		targets[i] = extended
+	}
+
+	return nil
+}
+
+// extendTarget returns the target, or template, merged over the templates it
+// extends in order. The stack holds the templates being extended to find cycles.
+func extendTarget(templates map[string]interface{}, target map[string]interface{}, stack []string) (map[string]interface{}, error) {
+	extends, err := stringList(target["extends"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid extends: %v", err)
+	}
+
+	base := make(map[string]interface{})
+	for _, name := range extends {
+		for _, s := range stack {
+			if s == name {
+				return nil, fmt.Errorf("templates extend each other: %s -> %s", strings.Join(stack, " -> "), name)
+			}
+		}
+
+		template, ok := templates[name].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unknown template %s", name)
+		}
+
+		extended, err := extendTarget(templates, template, append(stack[:len(stack):len(stack)], name))
+		if err != nil {
+			return nil, err
+		}
+
+		base = mergeTarget(base, extended)
+	}
+
+	merged := mergeTarget(base, target)
+	delete(merged, "extends")
+
+	return merged, nil
+}
+
+// mergeTarget returns the target merged over the base: objects are merged
+// deeply, middlewares by name and the other values are replaced
+func mergeTarget(base map[string]interface{}, target map[string]interface{}) map[string]interface{} {
+	merged := mergeValues(base, target).(map[string]interface{})
+
+	baseMiddlewares, _ := base["middlewares"].([]interface{})
+	middlewares, ok := target["middlewares"].([]interface{})
+	if !ok || len(baseMiddlewares) == 0 {
+		return merged
+	}
+
+	list := copyValue(baseMiddlewares).([]interface{})
+	for _, mw := range middlewares {
+		name := middlewareName(mw)
+
+		replaced := false
+		for i, existing := range list {
+			if name != "" && middlewareName(existing) == name {
+				list[i] = mergeValues(existing, mw)
+				replaced = true
+				break
+			}
+		}
+
+		if !replaced {
+			list = append(list, copyValue(mw))
+		}
+	}
+	merged["middlewares"] = list
+
+	return merged
+}
+
+func middlewareName(mw interface{}) string {
+	m, _ := mw.(map[string]interface{})
+	name, _ := m["name"].(string)
+	return name
+}
+
+// mergeValues returns a copy of the base with the objects of the value merged
+// over it, the other values replace the ones of the base
+func mergeValues(base interface{}, value interface{}) interface{} {
+	baseMap, ok := base.(map[string]interface{})
+	valueMap, ok2 := value.(map[string]interface{})
+	if !ok || !ok2 {
+		return copyValue(value)
+	}
+
+	merged := copyValue(baseMap).(map[string]interface{})
+	for key, v := range valueMap {
+		if existing, ok := merged[key]; ok {
+			merged[key] = mergeValues(existing, v)
+		} else {
+			merged[key] = copyValue(v)
+		}
+	}
+
+	return merged
+}
+
+// copyValue returns a deep copy of a decoded document
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			m[key] = copyValue(e)
+		}
+		return m
+
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = copyValue(e)
+		}
+		return l
+	}
+
+	return value
+}
@@ -0,0 +1,91 @@
+package traffikey
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Verbs that can be allowed to an API token
+const (
+	VERB_READ        = "read"
+	VERB_WRITE       = "write"
+	VERB_MAINTENANCE = "maintenance"
+)
+
+const TOKEN_HASH_PREFIX = "sha256:"
+
+// TokenConfig is a token allowed to use the HTTP APIs. Empty prefixes or
+// types allow all of them.
+type TokenConfig struct {
+	// Hash of the token as returned by HashToken
+	Hash     string   `json:"hash"`
+	Prefixes []string `json:"prefixes"`
+	Types    []string `json:"types"`
+	// Any of read, write and maintenance
+	Verbs []string `json:"verbs"`
+}
+
+// HashToken returns the hash of the token to put in the configuration
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return TOKEN_HASH_PREFIX + hex.EncodeToString(sum[:])
+}
+
+func (t *TokenConfig) validate() error {
+	hash, ok := strings.CutPrefix(t.Hash, TOKEN_HASH_PREFIX)
+	if b, err := hex.DecodeString(hash); !ok || err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("hash should be %s followed by the hex encoded SHA-256 of the token", TOKEN_HASH_PREFIX)
+	}
+
+	if len(t.Verbs) == 0 {
+		return fmt.Errorf("no verbs are allowed")
+	}
+	for _, verb := range t.Verbs {
+		switch verb {
+		case VERB_READ, VERB_WRITE, VERB_MAINTENANCE:
+		default:
+			return fmt.Errorf("unknown verb %s", verb)
+		}
+	}
+
+	return nil
+}
+
+// Matches returns whether the token is the one of the hash
+func (t *TokenConfig) Matches(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(t.Hash)) == 1
+}
+
+func scopeAllows(scope []string, value string) bool {
+	if len(scope) == 0 || value == "" {
+		return true
+	}
+
+	for _, s := range scope {
+		if s == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allows returns whether the token can use the verb on the targets of the
+// prefix and router type. Empty prefix or type are not checked.
+func (t *TokenConfig) Allows(verb, prefix, routerType string) bool {
+	for _, v := range t.Verbs {
+		if v == verb {
+			return scopeAllows(t.Prefixes, prefix) && scopeAllows(t.Types, routerType)
+		}
+	}
+
+	return false
+}
+
+// Scoped returns whether the token is limited to some prefixes or types
+func (t *TokenConfig) Scoped() bool {
+	return len(t.Prefixes) > 0 || len(t.Types) > 0
+}
@@ -0,0 +1,28 @@
+package traffikey
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenConfig(t *testing.T) {
+	token := &TokenConfig{
+		Hash:     HashToken("secret"),
+		Prefixes: []string{"traefik"},
+		Verbs:    []string{VERB_READ, VERB_MAINTENANCE},
+	}
+	assert.NoError(t, token.validate())
+
+	assert.True(t, token.Matches("secret"))
+	assert.False(t, token.Matches("secret2"))
+
+	assert.True(t, token.Allows(VERB_READ, "traefik", "tcp"))
+	assert.True(t, token.Allows(VERB_MAINTENANCE, "", ""))
+	assert.False(t, token.Allows(VERB_WRITE, "traefik", "http"))
+	assert.False(t, token.Allows(VERB_READ, "other", "http"))
+
+	assert.Error(t, (&TokenConfig{Hash: "secret", Verbs: []string{VERB_READ}}).validate(), "unhashed token")
+	assert.Error(t, (&TokenConfig{Hash: HashToken("secret")}).validate(), "no verbs")
+	assert.Error(t, (&TokenConfig{Hash: HashToken("secret"), Verbs: []string{"delete"}}).validate(), "unknown verb")
+}